@@ -5,13 +5,23 @@
 package irgo
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
 	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
+
+	"github.com/cznic/ir"
 )
 
 func caller(s string, va ...interface{}) {
@@ -42,7 +52,7 @@ func dbg(s string, va ...interface{}) {
 func use(...interface{}) {}
 
 func init() {
-	use(caller, dbg, TODO) //TODOOK
+	use(caller, dbg)
 	flag.BoolVar(&Testing, "testing", false, "")
 }
 
@@ -51,3 +61,1538 @@ func init() {
 func Test(t *testing.T) {
 	t.Log("TODO")
 }
+
+// crtStub is the part of the C runtime used by the generated code run by the
+// tests.
+const crtStub = `package crt
+
+import "unsafe"
+
+type TLS struct{}
+
+func P2U(p unsafe.Pointer) uintptr { return uintptr(p) }
+func U2P(u uintptr) unsafe.Pointer { return unsafe.Pointer(u) }
+`
+
+// run vets and runs the Go code generated for obj as package main having the
+// main function body main. It returns the output of the program.
+func run(t *testing.T, obj []ir.Object, main string, opts ...Option) string {
+	return runFiles(t, nil, obj, main, opts...)
+}
+
+// runFiles is like run but it first writes files, a map of module relative
+// file names to their contents.
+func runFiles(t *testing.T, files map[string]string, obj []ir.Object, main string, opts ...Option) string {
+	if testing.Short() {
+		t.Skip("running generated code")
+	}
+
+	dir, err := ioutil.TempDir("", "irgo-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	if err := New(&buf, obj, nil, append([]Option{Package("main"), CRT("irgo.test/crt", "crt")}, opts...)...); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range files {
+		nm := filepath.Join(dir, k)
+		if err := os.MkdirAll(filepath.Dir(nm), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(nm, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, v := range []struct{ nm, src string }{
+		{"go.mod", "module irgo.test\n\nrequire irgo.test/crt v0.0.0\n\nreplace irgo.test/crt => ./crt\n"},
+		{"crt/go.mod", "module irgo.test/crt\n"},
+		{"crt/crt.go", crtStub},
+		{"gen.go", buf.String()},
+		{"main.go", "package main\n\nimport (\n\"fmt\"\n\"unsafe\"\n\n\"irgo.test/crt\"\n)\n\nvar (\n_ = fmt.Println\n_ = unsafe.Pointer(nil)\n)\n\nfunc main() {\ntls := &crt.TLS{}\n_ = tls\n" + main + "\n}\n"},
+	} {
+		nm := filepath.Join(dir, v.nm)
+		if err := os.MkdirAll(filepath.Dir(nm), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(nm, []byte(v.src), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out []byte
+	for _, v := range []string{"vet", "run"} {
+		cmd := exec.Command("go", v, ".")
+		cmd.Dir = dir
+		if out, err = cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%s\n%s\n%v", buf.Bytes(), out, err)
+		}
+	}
+	return string(out)
+}
+
+func fnDef(nm, typ string, line int, body ...ir.Operation) *ir.FunctionDefinition {
+	return &ir.FunctionDefinition{
+		ObjectBase: ir.ObjectBase{
+			Linkage:  ir.ExternalLinkage,
+			NameID:   ir.NameID(dict.SID(nm)),
+			Position: token.Position{Filename: "test.c", Line: line},
+			TypeID:   ir.TypeID(dict.SID(typ)),
+		},
+		Body: append(append([]ir.Operation{&ir.BeginScope{}}, body...), &ir.Return{}, &ir.EndScope{}),
+	}
+}
+
+func TestErrorList(t *testing.T) {
+	pi8 := ir.TypeID(dict.SID("*int8"))
+	s := &ir.StringValue{Offset: 1}
+	obj := []ir.Object{
+		fnDef("f", "func()", 10, &ir.VariableDeclaration{NameID: ir.NameID(dict.SID("x")), TypeID: pi8, Value: s}),
+		fnDef("g", "func()", 20),
+		fnDef("h", "func()", 30, &ir.VariableDeclaration{NameID: ir.NameID(dict.SID("y")), TypeID: pi8, Value: s}),
+	}
+	var buf bytes.Buffer
+	err := New(&buf, obj, nil)
+	l, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("%T(%v)", err, err)
+	}
+
+	if g, e := len(l), 2; g != e {
+		t.Fatalf("got %v, expected %v\n%v", g, e, l)
+	}
+
+	for i, v := range []int{10, 30} {
+		if g, e := l[i].Position.Line, v; g != e {
+			t.Errorf("got %v, expected %v", g, e)
+		}
+		if g, e := l[i].Severity, Error; g != e {
+			t.Errorf("got %v, expected %v", g, e)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected output\n%s", buf.Bytes())
+	}
+}
+
+func TestUnsupportedConstant(t *testing.T) {
+	u64 := ir.TypeID(dict.SID("uint64"))
+	i8 := ir.TypeID(dict.SID("int8"))
+	set := func(v int64, line int) []ir.Operation {
+		pos := token.Position{Filename: "test.c", Line: line}
+		return []ir.Operation{
+			&ir.Result{Address: true, TypeID: ir.TypeID(dict.SID("*int8"))},
+			&ir.Const64{TypeID: u64, Value: v, Position: pos},
+			&ir.Convert{TypeID: u64, Result: i8, Position: pos},
+			&ir.Store{TypeID: i8},
+			&ir.Drop{TypeID: i8},
+		}
+	}
+	obj := []ir.Object{fnDef("f", "func()int8", 10, append(set(300, 11), set(400, 12)...)...)}
+	err := New(ioutil.Discard, obj, nil)
+	l, ok := err.(ErrorList)
+	if !ok || len(l) != 2 {
+		t.Fatalf("%T(%v)", err, err)
+	}
+
+	// Every unsupported expression of the function is reported.
+	for i, e := range []string{
+		"test.c:11: error: unsupported conversion of constant 300 of type uint64 to int8 (*ir.Const64)",
+		"test.c:12: error: unsupported conversion of constant 400 of type uint64 to int8 (*ir.Const64)",
+	} {
+		if g := l[i].Error(); g != e {
+			t.Fatalf("got %q, expected %q", g, e)
+		}
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	obj := []ir.Object{
+		fnDef("f", "func()", 10, &ir.VariableDeclaration{NameID: ir.NameID(dict.SID("x")), TypeID: ir.TypeID(dict.SID("*int8")), Value: &ir.StringValue{Offset: 1}}),
+		fnDef("g", "func()", 20),
+	}
+	var buf bytes.Buffer
+	var w []*Diagnostic
+	if err := New(&buf, obj, nil, ContinueOnError(), Warnings(func(d *Diagnostic) { w = append(w, d) })); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := len(w), 1; g != e {
+		t.Fatalf("got %v, expected %v", g, e)
+	}
+
+	if g, e := w[0].Severity, Warning; g != e {
+		t.Fatalf("got %v, expected %v", g, e)
+	}
+
+	s := buf.String()
+	for _, v := range []string{
+		"func Xf(tls *crt.TLS) {\n\tpanic(\"test.c:10: unsupported string value with offset\")\n}",
+		"func Xg(tls *crt.TLS) {",
+	} {
+		if !strings.Contains(s, v) {
+			t.Fatalf("missing %q in\n%s", v, s)
+		}
+	}
+}
+
+func TestPackage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := New(&buf, []ir.Object{fnDef("f", "func()", 10)}, nil, Package("foo"), CRT("example.com/crt", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := buf.String(), "package foo\n\nimport (\n\t\"math\"\n\n\t\"example.com/crt\"\n)\n"; !strings.HasPrefix(g, e) {
+		t.Fatalf("got\n%s\nexpected prefix\n%s", g, e)
+	}
+
+	buf.Reset()
+	if err := New(&buf, []ir.Object{fnDef("f", "func()", 10)}, nil, Package("foo"), CRT("example.com/stub/v2", "rt")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := buf.String()
+	for _, v := range []string{
+		"\trt \"example.com/stub/v2\"\n",
+		"func Xf(tls *rt.TLS) {",
+	} {
+		if !strings.Contains(s, v) {
+			t.Fatalf("missing %q in\n%s", v, s)
+		}
+	}
+
+	buf.Reset()
+	if err := New(&buf, []ir.Object{fnDef("f", "func()", 10)}, nil, Package("foo"), CRT("example.com/crt/v2", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := buf.String(); !strings.Contains(s, "\tcrt \"example.com/crt/v2\"\n") || !strings.Contains(s, "func Xf(tls *crt.TLS) {") {
+		t.Fatalf("unexpected C runtime package name\n%s", s)
+	}
+
+	for _, v := range []string{"inf", "labels3", "str", "t12"} {
+		if err := New(&buf, nil, nil, Package("foo"), CRT("example.com/crt", v)); err == nil || !strings.Contains(err.Error(), "conflicts") {
+			t.Fatalf("%s: unexpected error %v", v, err)
+		}
+	}
+
+	if err := New(&buf, nil, nil, Package("a-b")); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestNewFiles(t *testing.T) {
+	obj := []ir.Object{
+		fnDef("f", "func()", 10),
+		fnDef("g", "func()", 20),
+		fnDef("h", "func()", 30),
+	}
+	files := map[string]*bytes.Buffer{}
+	var names []string
+	sink := func(nm string) io.Writer {
+		names = append(names, nm)
+		files[nm] = &bytes.Buffer{}
+		return files[nm]
+	}
+	if err := NewFiles(sink, obj, nil, Package("foo"), MaxFileSize(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := strings.Join(names, " "), "code0.go code1.go code2.go helpers.go"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	for i, nm := range names {
+		b := files[nm].Bytes()
+		if !bytes.HasPrefix(b, []byte("package foo\n")) {
+			t.Fatalf("%s: missing package clause\n%s", nm, b)
+		}
+
+		if i < len(obj) {
+			if g, e := bytes.Count(b, []byte("func X")), 1; g != e {
+				t.Fatalf("%s: got %v functions, expected %v\n%s", nm, g, e, b)
+			}
+		}
+	}
+
+	names = nil
+	if err := NewFiles(sink, obj, nil, Package("foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := strings.Join(names, " "), "code0.go helpers.go"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	if err := NewFiles(sink, obj, nil); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestLink(t *testing.T) {
+	i32 := ir.TypeID(dict.SID("int32"))
+	pi32 := ir.TypeID(dict.SID("*int32"))
+	x := ir.NameID(dict.SID("x"))
+	unit := func(nm string, line int) []ir.Object {
+		return []ir.Object{
+			&ir.DataDefinition{
+				ObjectBase: ir.ObjectBase{Linkage: ir.InternalLinkage, NameID: x, Position: token.Position{Filename: "test.c", Line: line}, TypeID: i32},
+				Value:      &ir.Int32Value{Value: int32(line)},
+			},
+			&ir.DataDefinition{
+				ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID(nm)), Position: token.Position{Filename: "test.c", Line: line + 1}, TypeID: pi32},
+				Value:      &ir.AddressValue{Linkage: ir.InternalLinkage, NameID: x},
+			},
+		}
+	}
+	obj, err := Link(unit("p", 10), unit("q", 20), []ir.Object{fnDef("f", "func()", 30)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := New(&buf, obj, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	s := buf.String()
+	for _, v := range []string{"_x = int32(10)", "_x_1 = int32(20)", "Xp = &_x\n", "Xq = &_x_1\n", "func Xf("} {
+		if !strings.Contains(s, v) {
+			t.Fatalf("missing %q\n%s", v, s)
+		}
+	}
+	if strings.Contains(s, "Xmain") {
+		t.Fatalf("unexpected main\n%s", s)
+	}
+
+	_, err = Link([]ir.Object{fnDef("f", "func()", 10)}, []ir.Object{fnDef("f", "func()", 20)})
+	l, ok := err.(ErrorList)
+	if !ok || len(l) != 1 {
+		t.Fatalf("%T(%v)", err, err)
+	}
+
+	if g, e := l[0].Error(), "test.c:20: error: duplicate definition of f, previous definition at test.c:10 (*ir.FunctionDefinition)"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+}
+
+func TestImports(t *testing.T) {
+	lib := ir.NameID(dict.SID("lib"))
+	v := ir.NameID(dict.SID("v"))
+	obj := []ir.Object{
+		&ir.DataDefinition{
+			ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: v, Package: lib, TypeID: ir.TypeID(dict.SID("int32"))},
+		},
+		&ir.DataDefinition{
+			ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID("p")), Position: token.Position{Filename: "test.c", Line: 10}, TypeID: ir.TypeID(dict.SID("*int32"))},
+			Value:      &ir.AddressValue{Linkage: ir.ExternalLinkage, NameID: v},
+		},
+	}
+	var buf bytes.Buffer
+	if err := New(&buf, obj, nil, Package("foo"), Imports(map[ir.NameID]string{lib: "example.com/c/lib"})); err != nil {
+		t.Fatal(err)
+	}
+
+	s := buf.String()
+	for _, v := range []string{"\"example.com/c/lib\"\n", "Xp = &lib.Xv\n"} {
+		if !strings.Contains(s, v) {
+			t.Fatalf("missing %q\n%s", v, s)
+		}
+	}
+	if strings.Contains(s, "var Xv") {
+		t.Fatalf("unexpected definition\n%s", s)
+	}
+
+	buf.Reset()
+	if err := New(&buf, obj, nil, Package("foo")); err == nil || !strings.Contains(err.Error(), "no import path for package lib") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// Call a function of another generated package.
+	i32 := ir.TypeID(dict.SID("int32"))
+	pi32 := ir.TypeID(dict.SID("*int32"))
+	g := fnDef("g", "func(int32)int32", 10,
+		&ir.Result{Address: true, TypeID: pi32},
+		&ir.Argument{TypeID: i32},
+		&ir.Const32{TypeID: i32, Value: 2},
+		&ir.Mul{TypeID: i32},
+		&ir.Store{TypeID: i32},
+		&ir.Drop{TypeID: i32},
+	)
+	g.Arguments = []ir.NameID{ir.NameID(dict.SID("n"))}
+	buf.Reset()
+	if err := New(&buf, []ir.Object{g}, nil, Package("lib"), CRT("irgo.test/crt", "crt")); err != nil {
+		t.Fatal(err)
+	}
+
+	decl := &ir.FunctionDefinition{ObjectBase: g.ObjectBase, Body: []ir.Operation{&ir.Panic{}}}
+	decl.Package = lib
+	f := fnDef("f", "func()int32", 20,
+		&ir.Result{Address: true, TypeID: pi32},
+		&ir.Arguments{},
+		&ir.Const32{TypeID: i32, Value: 21},
+		&ir.Call{Arguments: 1, Index: 0, TypeID: g.TypeID},
+		&ir.Store{TypeID: i32},
+		&ir.Drop{TypeID: i32},
+	)
+	files := map[string]string{"lib/lib.go": buf.String()}
+	if g, e := runFiles(t, files, []ir.Object{decl, f}, "fmt.Println(Xf(tls))", Imports(map[ir.NameID]string{lib: "irgo.test/lib"})), "42\n"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+}
+
+func TestTarget(t *testing.T) {
+	obj := []ir.Object{
+		&ir.DataDefinition{
+			ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID("p")), TypeID: ir.TypeID(dict.SID("*int8"))},
+			Value:      &ir.Int32Value{Value: -1},
+		},
+	}
+	for _, v := range []struct {
+		goarch, hdr, val string
+	}{
+		{"386", "//go:build linux && 386\n// +build linux,386\n\npackage foo\n", "U2P(4294967295)"},
+		{"amd64", "//go:build linux && amd64\n// +build linux,amd64\n\npackage foo\n", "U2P(18446744073709551615)"},
+	} {
+		var buf bytes.Buffer
+		if err := New(&buf, obj, nil, Package("foo"), Target("linux", v.goarch)); err != nil {
+			t.Fatal(err)
+		}
+
+		s := buf.String()
+		if !strings.HasPrefix(s, v.hdr) || !strings.Contains(s, v.val) {
+			t.Fatalf("%s: unexpected output\n%s", v.goarch, s)
+		}
+	}
+
+	if err := New(ioutil.Discard, obj, nil, Target("linux", "mips")); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestPortable(t *testing.T) {
+	a := ir.NameID(dict.SID("a"))
+	obj := []ir.Object{
+		&ir.DataDefinition{
+			ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: a, TypeID: ir.TypeID(dict.SID("[4]*int8"))},
+		},
+		&ir.DataDefinition{
+			ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID("p")), TypeID: ir.TypeID(dict.SID("**int8"))},
+			Value:      &ir.AddressValue{Linkage: ir.ExternalLinkage, NameID: a, Offset: 16},
+		},
+		&ir.DataDefinition{
+			ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID("u")), TypeID: ir.TypeID(dict.SID("union{i int32,p *int8}"))},
+		},
+	}
+	var buf bytes.Buffer
+	if err := New(&buf, obj, nil, Portable(), Target("linux", "amd64")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := buf.String()
+	for _, v := range []string{"Xp = (**int8)(unsafe.Pointer(&Xa[2]))\n", "U [unsafe.Sizeof(*(**int8)(nil))]byte"} {
+		if !strings.Contains(s, v) {
+			t.Fatalf("missing %q\n%s", v, s)
+		}
+	}
+
+	// Lowered to float64, a long double member is smaller than the struct
+	// member on 64 bit targets only.
+	u := &ir.DataDefinition{
+		ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID("v")), TypeID: ir.TypeID(dict.SID("union{d float128,s struct{i int32,p *int8}}"))},
+		Value:      &ir.CompositeValue{Values: []ir.Value{&ir.Float64Value{Value: 1}}},
+	}
+	buf.Reset()
+	if err := New(&buf, []ir.Object{u}, nil, Portable(), Target("linux", "amd64")); err != nil {
+		t.Fatal(err)
+	}
+
+	s = buf.String()
+	for _, v := range []string{"U [unsafe.Sizeof(*(*t1)(nil))]byte", "Xv = t0{U: [len(t0{}.U)]byte{0, 0, 0, 0, 0, 0, 0xf0, 0x3f}}"} {
+		if !strings.Contains(s, v) {
+			t.Fatalf("missing %q\n%s", v, s)
+		}
+	}
+}
+
+func TestMultipleResults(t *testing.T) {
+	i32 := ir.TypeID(dict.SID("int32"))
+	ft := ir.TypeID(dict.SID("func()(int32,int32)"))
+	obj := []ir.Object{
+		fnDef("g", "func()(int32,int32)", 10,
+			&ir.Result{Address: true, Index: 1, TypeID: ir.TypeID(dict.SID("*int32"))},
+			&ir.Const32{TypeID: i32, Value: 2},
+			&ir.Store{TypeID: i32},
+			&ir.Drop{TypeID: i32},
+			&ir.Result{Address: true, TypeID: ir.TypeID(dict.SID("*int32"))},
+			&ir.Const32{TypeID: i32, Value: 1},
+			&ir.Store{TypeID: i32},
+			&ir.Drop{TypeID: i32},
+		),
+		fnDef("f", "func()", 20,
+			&ir.Arguments{},
+			&ir.Call{Index: 0, TypeID: ft},
+			&ir.Drop{TypeID: i32},
+			&ir.Drop{TypeID: i32},
+		),
+		fnDef("h", "func()int32", 30,
+			&ir.Result{Address: true, TypeID: ir.TypeID(dict.SID("*int32"))},
+			&ir.Call{Index: 0, TypeID: ft},
+			&ir.Sub{TypeID: i32},
+			&ir.Store{TypeID: i32},
+			&ir.Drop{TypeID: i32},
+		),
+		fnDef("k", "func()int32", 40,
+			&ir.Result{Address: true, TypeID: ir.TypeID(dict.SID("*int32"))},
+			&ir.Call{Index: 0, TypeID: ft},
+			&ir.Drop{TypeID: i32},
+			&ir.Store{TypeID: i32},
+			&ir.Drop{TypeID: i32},
+		),
+		&ir.DataDefinition{
+			ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID("fp")), TypeID: ir.TypeID(dict.SID("*func()(int32,int32)"))},
+		},
+	}
+	var buf bytes.Buffer
+	if err := New(&buf, obj, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	s := buf.String()
+	for _, v := range []string{
+		"func Xg(tls *crt.TLS) (r0 int32, r1 int32) {\n\tr1 = int32(2)\n\tr0 = int32(1)\n\treturn\n}",
+		"func Xf(tls *crt.TLS) {\n\tXg(tls)\n}",
+		"var Xfp func(*crt.TLS) (int32, int32)\n",
+		"func Xh(tls *crt.TLS) (r0 int32) {\n\tvar _0, _1 int32\n\t_0, _1 = Xg(tls)\n\treturn _0 - _1\n}",
+		"func Xk(tls *crt.TLS) (r0 int32) {\n\tvar _0 int32\n\t_0, _ = Xg(tls)\n\treturn _0\n}",
+	} {
+		if !strings.Contains(s, v) {
+			t.Fatalf("missing %q\n%s", v, s)
+		}
+	}
+
+	if g, e := run(t, obj, "fmt.Println(Xh(tls), Xk(tls))"), "-1 1\n"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+}
+
+// TestMultipleResultsOrder checks that the operands left of a call of a
+// function returning multiple values are computed before the call.
+func TestMultipleResultsOrder(t *testing.T) {
+	i32 := ir.TypeID(dict.SID("int32"))
+	pi32 := ir.TypeID(dict.SID("*int32"))
+	c := &ir.DataDefinition{
+		ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID("c")), TypeID: i32},
+	}
+	addr := &ir.Global{Address: true, NameID: c.NameID, TypeID: pi32, Linkage: ir.ExternalLinkage}
+	val := &ir.Global{NameID: c.NameID, TypeID: i32, Linkage: ir.ExternalLinkage}
+	obj := []ir.Object{
+		c,
+		// inc returns ++c.
+		fnDef("inc", "func()int32", 10,
+			&ir.Result{Address: true, TypeID: pi32},
+			addr,
+			val,
+			&ir.Const32{TypeID: i32, Value: 1},
+			&ir.Add{TypeID: i32},
+			&ir.Store{TypeID: i32},
+			&ir.Store{TypeID: i32},
+			&ir.Drop{TypeID: i32},
+		),
+		// g returns c*10, c.
+		fnDef("g", "func()(int32,int32)", 20,
+			&ir.Result{Address: true, Index: 1, TypeID: pi32},
+			val,
+			&ir.Store{TypeID: i32},
+			&ir.Drop{TypeID: i32},
+			&ir.Result{Address: true, TypeID: pi32},
+			val,
+			&ir.Const32{TypeID: i32, Value: 10},
+			&ir.Mul{TypeID: i32},
+			&ir.Store{TypeID: i32},
+			&ir.Drop{TypeID: i32},
+		),
+		// f returns inc() + (r0 - r1) where r0, r1 = g().
+		fnDef("f", "func()int32", 30,
+			&ir.Result{Address: true, TypeID: pi32},
+			&ir.Arguments{},
+			&ir.Call{Index: 1, TypeID: ir.TypeID(dict.SID("func()int32"))},
+			&ir.Arguments{},
+			&ir.Call{Index: 2, TypeID: ir.TypeID(dict.SID("func()(int32,int32)"))},
+			&ir.Sub{TypeID: i32},
+			&ir.Add{TypeID: i32},
+			&ir.Store{TypeID: i32},
+			&ir.Drop{TypeID: i32},
+		),
+	}
+	var buf bytes.Buffer
+	if err := New(&buf, obj, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := buf.String(), "\t_0 = Xinc(tls)\n\t_1, _2 = Xg(tls)\n\treturn _0 + (_1 - _2)\n"; !strings.Contains(g, e) {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+
+	if g, e := run(t, obj, "fmt.Println(Xf(tls))"), "10\n"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+}
+
+func TestStructured(t *testing.T) {
+	i32 := ir.TypeID(dict.SID("int32"))
+	pi32 := ir.TypeID(dict.SID("*int32"))
+	v := func(i int) ir.Operation { return &ir.Variable{Index: i, TypeID: i32} }
+	n := &ir.Argument{TypeID: i32}
+	c := func(n int32) ir.Operation { return &ir.Const32{TypeID: i32, Value: n} }
+	set := func(i int, val ...ir.Operation) []ir.Operation {
+		return append(append([]ir.Operation{&ir.Variable{Address: true, Index: i, TypeID: pi32}}, val...), &ir.Store{TypeID: i32}, &ir.Drop{TypeID: i32})
+	}
+	var body []ir.Operation
+	for _, v := range [][]ir.Operation{
+		{
+			&ir.VariableDeclaration{Index: 0, NameID: ir.NameID(dict.SID("i")), TypeID: i32},
+			&ir.VariableDeclaration{Index: 1, NameID: ir.NameID(dict.SID("j")), TypeID: i32},
+		},
+		set(0, c(0)),
+		{&ir.Label{Number: 1}, v(0), n, &ir.Lt{TypeID: i32}, &ir.Jz{Number: 4}},
+		set(1, c(0)),
+		{&ir.Label{Number: 2}, v(1), n, &ir.Lt{TypeID: i32}, &ir.Jz{Number: 3}},
+		{v(0), v(1), &ir.Mul{TypeID: i32}, c(20), &ir.Gt{TypeID: i32}, &ir.Jnz{Number: 4}},
+		set(1, v(1), c(1), &ir.Add{TypeID: i32}),
+		{&ir.Jmp{Number: 2}, &ir.Label{Number: 3}},
+		set(0, v(0), c(1), &ir.Add{TypeID: i32}),
+		{&ir.Jmp{Number: 1}, &ir.Label{Number: 4}, &ir.Result{Address: true, TypeID: pi32}, v(0)},
+		{&ir.Store{TypeID: i32}, &ir.Drop{TypeID: i32}},
+	} {
+		body = append(body, v...)
+	}
+	f := fnDef("f", "func(int32)int32", 10, body...)
+	f.Arguments = []ir.NameID{ir.NameID(dict.SID("n"))}
+
+	// A loop entered at two points followed by a reducible one.
+	body = nil
+	for _, v := range [][]ir.Operation{
+		{
+			&ir.VariableDeclaration{Index: 0, NameID: ir.NameID(dict.SID("i")), TypeID: i32},
+			&ir.VariableDeclaration{Index: 1, NameID: ir.NameID(dict.SID("j")), TypeID: i32},
+		},
+		set(0, c(0)),
+		set(1, c(0)),
+		{n, c(1), &ir.And{TypeID: i32}, &ir.Jnz{Number: 2}, &ir.Label{Number: 1}},
+		set(0, v(0), c(1), &ir.Add{TypeID: i32}),
+		{v(0), n, &ir.Gt{TypeID: i32}, &ir.Jnz{Number: 3}, &ir.Label{Number: 2}},
+		set(0, v(0), c(2), &ir.Add{TypeID: i32}),
+		{v(0), n, &ir.Lt{TypeID: i32}, &ir.Jnz{Number: 1}, &ir.Label{Number: 3}, v(1), n, &ir.Lt{TypeID: i32}, &ir.Jz{Number: 4}},
+		set(1, v(1), v(0), &ir.Add{TypeID: i32}),
+		{&ir.Jmp{Number: 3}, &ir.Label{Number: 4}, &ir.Result{Address: true, TypeID: pi32}, v(1)},
+		{&ir.Store{TypeID: i32}, &ir.Drop{TypeID: i32}},
+	} {
+		body = append(body, v...)
+	}
+	g := fnDef("g", "func(int32)int32", 20, body...)
+	g.Arguments = []ir.NameID{ir.NameID(dict.SID("n"))}
+	obj := []ir.Object{f, g}
+	var buf bytes.Buffer
+	if err := New(&buf, obj, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []string{
+		`func Xf(tls *crt.TLS, _n int32) (r0 int32) {
+	var _i, _j int32
+	_i = int32(0)
+_1:
+	for _i < _n {
+		_j = int32(0)
+		for _j < _n {
+			if (_i * _j) > int32(20) {
+				break _1
+			}
+			_j = _j + int32(1)
+		}
+		_i = _i + int32(1)
+	}
+	return _i
+}
+`,
+		`func Xg(tls *crt.TLS, _n int32) (r0 int32) {
+	var _i, _j int32
+	_i = int32(0)
+	_j = int32(0)
+	if (_n & int32(1)) != 0 {
+		goto _2
+	}
+_1:
+	_i = _i + int32(1)
+	if _i > _n {
+		goto _3
+	}
+_2:
+	_i = _i + int32(2)
+	if _i < _n {
+		goto _1
+	}
+_3:
+	for _j < _n {
+		_j = _j + _i
+	}
+	return _j
+}
+`,
+	} {
+		if g := buf.String(); !strings.Contains(g, e) {
+			t.Fatalf("got\n%s\nexp\n%s", g, e)
+		}
+	}
+
+	if g, e := run(t, obj, "for i := int32(0); i < 6; i++ {\nfmt.Print(Xg(tls, i), \" \")\n}"), "0 2 3 5 6 5 "; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	i32 := ir.TypeID(dict.SID("int32"))
+	pi32 := ir.TypeID(dict.SID("*int32"))
+	n := &ir.Argument{TypeID: i32}
+	c := func(n int32) ir.Operation { return &ir.Const32{TypeID: i32, Value: n} }
+	ret := func(val ...ir.Operation) []ir.Operation {
+		return append(append([]ir.Operation{&ir.Result{Address: true, TypeID: pi32}}, val...), &ir.Store{TypeID: i32}, &ir.Drop{TypeID: i32}, &ir.Return{})
+	}
+	var body []ir.Operation
+	for _, v := range [][]ir.Operation{
+		{
+			&ir.VariableDeclaration{Index: 0, NameID: ir.NameID(dict.SID("s")), TypeID: i32},
+			&ir.Variable{Address: true, Index: 0, TypeID: pi32}, c(0), &ir.Store{TypeID: i32}, &ir.Drop{TypeID: i32},
+			n,
+			&ir.Switch{
+				TypeID:  i32,
+				Values:  []ir.Value{&ir.Int32Value{Value: 1}, &ir.Int32Value{Value: 2}, &ir.Int32Value{Value: 3}},
+				Labels:  []ir.Label{{Number: 1}, {Number: 2}, {Number: 1}},
+				Default: ir.Label{Number: 3},
+			},
+			&ir.Label{Number: 1},
+		},
+		ret(c(10)),
+		{&ir.Label{Number: 2}, &ir.Variable{Address: true, Index: 0, TypeID: pi32}, c(20), &ir.Store{TypeID: i32}, &ir.Drop{TypeID: i32}},
+		{&ir.Label{Number: 3}},
+		ret(&ir.Variable{Index: 0, TypeID: i32}, c(1), &ir.Add{TypeID: i32}),
+	} {
+		body = append(body, v...)
+	}
+	f := fnDef("f", "func(int32)int32", 10, body...)
+	f.Arguments = []ir.NameID{ir.NameID(dict.SID("n"))}
+	var buf bytes.Buffer
+	if err := New(&buf, []ir.Object{f}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := buf.String(), `func Xf(tls *crt.TLS, _n int32) (r0 int32) {
+	var _s int32
+	_s = int32(0)
+	switch _n {
+	case int32(1), int32(3):
+		return int32(10)
+	case int32(2):
+		_s = int32(20)
+		fallthrough
+	default:
+		return _s + int32(1)
+	}
+}
+`; !strings.Contains(g, e) {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+}
+
+// TestGotos checks the structured control flow computes the same results as
+// the plain labels and gotos.
+func TestGotos(t *testing.T) {
+	i32 := ir.TypeID(dict.SID("int32"))
+	pi32 := ir.TypeID(dict.SID("*int32"))
+	n := &ir.Argument{TypeID: i32}
+	c := func(n int32) ir.Operation { return &ir.Const32{TypeID: i32, Value: n} }
+	v := func(i int) ir.Operation { return &ir.Variable{Index: i, TypeID: i32} }
+	lbl := func(n int) ir.Operation { return &ir.Label{Number: n} }
+	add := func(i int, d int32) []ir.Operation {
+		return []ir.Operation{&ir.Variable{Address: true, Index: i, TypeID: pi32}, v(i), c(d), &ir.Add{TypeID: i32}, &ir.Store{TypeID: i32}, &ir.Drop{TypeID: i32}}
+	}
+	sw := func(def int, pairs ...int) ir.Operation {
+		x := &ir.Switch{TypeID: i32, Default: ir.Label{Number: def}}
+		for i := 0; i < len(pairs); i += 2 {
+			x.Values = append(x.Values, &ir.Int32Value{Value: int32(pairs[i])})
+			x.Labels = append(x.Labels, ir.Label{Number: pairs[i+1]})
+		}
+		return x
+	}
+	fn := func(nm string, vars int, ops ...[]ir.Operation) *ir.FunctionDefinition {
+		var body []ir.Operation
+		for i := 0; i < vars; i++ {
+			body = append(body, &ir.VariableDeclaration{Index: i, NameID: ir.NameID(dict.SID(fmt.Sprint("v", i))), TypeID: i32, Value: &ir.Int32Value{}})
+		}
+		for _, v := range ops {
+			body = append(body, v...)
+		}
+		body = append(body, &ir.Result{Address: true, TypeID: pi32}, v(vars-1), &ir.Store{TypeID: i32}, &ir.Drop{TypeID: i32})
+		f := fnDef(nm, "func(int32)int32", 10, body...)
+		f.Arguments = []ir.NameID{ir.NameID(dict.SID("n"))}
+		return f
+	}
+	obj := []ir.Object{
+		// A loop entered at two points is irreducible.
+		fn("irreducible", 1,
+			[]ir.Operation{n, c(1), &ir.And{TypeID: i32}, &ir.Jnz{Number: 2}, lbl(1)},
+			add(0, 1),
+			[]ir.Operation{v(0), n, &ir.Gt{TypeID: i32}, &ir.Jnz{Number: 3}, lbl(2)},
+			add(0, 2),
+			[]ir.Operation{v(0), n, &ir.Lt{TypeID: i32}, &ir.Jnz{Number: 1}, lbl(3)},
+		),
+		// A switch in a loop continuing and breaking the loop.
+		fn("loop", 2,
+			[]ir.Operation{lbl(1), v(0), n, &ir.Lt{TypeID: i32}, &ir.Jz{Number: 9}},
+			[]ir.Operation{v(0), c(5), &ir.Rem{TypeID: i32}, sw(5, 0, 6, 4, 9, 2, 4), lbl(6)},
+			add(0, 3),
+			[]ir.Operation{&ir.Jmp{Number: 1}, lbl(4)},
+			add(1, 10),
+			[]ir.Operation{&ir.Jmp{Number: 7}, lbl(5)},
+			add(1, 1),
+			[]ir.Operation{lbl(7)},
+			add(1, 100),
+			add(0, 1),
+			[]ir.Operation{&ir.Jmp{Number: 1}, lbl(9)},
+		),
+		// A jump into a switch clause.
+		fn("into", 1,
+			[]ir.Operation{n, c(10), &ir.Gt{TypeID: i32}, &ir.Jnz{Number: 2}},
+			[]ir.Operation{n, sw(4, 1, 1, 2, 2), lbl(1)},
+			add(0, 1),
+			[]ir.Operation{lbl(2)},
+			add(0, 2),
+			[]ir.Operation{&ir.Jmp{Number: 3}, lbl(4)},
+			add(0, 4),
+			[]ir.Operation{lbl(3)},
+		),
+	}
+	var buf bytes.Buffer
+	if err := New(&buf, obj, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	g := buf.String()
+	for _, e := range []string{
+		"\tif (_n & int32(1)) != 0 {\n\t\tgoto _2\n\t}\n",
+		"\t\t\t_v0 = _v0 + int32(3)\n\t\t\tcontinue\n",
+		"\t\t\tbreak _1\n",
+		"\tif _n <= int32(10) {\n\t\tswitch _n {\n",
+	} {
+		if !strings.Contains(g, e) {
+			t.Fatalf("got\n%s\nexp\n%s", g, e)
+		}
+	}
+
+	main := "for i := int32(0); i < 14; i++ {\nfmt.Println(Xirreducible(tls, i), Xloop(tls, i), Xinto(tls, i))\n}"
+	structured := run(t, obj, main)
+	unstructured = true
+	defer func() { unstructured = false }()
+	if g := run(t, obj, main); g != structured {
+		t.Fatalf("got\n%s\nexp\n%s", structured, g)
+	}
+}
+
+// TestRandomCFG checks functions with random control flow compute the same
+// results as an interpreter of their blocks.
+func TestRandomCFG(t *testing.T) {
+	const funcs, blocks = 500, 9
+	i32 := ir.TypeID(dict.SID("int32"))
+	pi32 := ir.TypeID(dict.SID("*int32"))
+	n := &ir.Argument{TypeID: i32}
+	c := func(n int32) ir.Operation { return &ir.Const32{TypeID: i32, Value: n} }
+	v := func(i int) ir.Operation { return &ir.Variable{Index: i, TypeID: i32} }
+	set := func(i int, val ...ir.Operation) []ir.Operation {
+		return append(append([]ir.Operation{&ir.Variable{Address: true, Index: i, TypeID: pi32}}, val...), &ir.Store{TypeID: i32}, &ir.Drop{TypeID: i32})
+	}
+	ret := []ir.Operation{&ir.Result{Address: true, TypeID: pi32}, v(0), &ir.Store{TypeID: i32}, &ir.Drop{TypeID: i32}}
+
+	// Block i, numbered from 1, sets v0 to 3*v0+i and exits the function
+	// when v1, the number of blocks entered, exceeds 40. It continues
+	// according to kind.
+	type blk struct {
+		kind  int   // Fall through, jump, jump if v0+n is odd, switch on (v0+n)%4, return.
+		to    int   // Jump or switch default target, blocks+1 is the exit.
+		cases []int // Switch targets.
+	}
+	eval := func(a []blk, n int32) int32 {
+		var v0, v1 int32
+		for i := 1; i <= len(a); {
+			b := a[i-1]
+			v0 = 3*v0 + int32(i)
+			if v1++; v1 > 40 {
+				break
+			}
+
+			switch b.kind {
+			case 0:
+				i++
+			case 1:
+				i = b.to
+			case 2:
+				i++
+				if (v0+n)&1 != 0 {
+					i = b.to
+				}
+			case 3:
+				i = b.to
+				if r := (v0 + n) % 4; r >= 0 && int(r) < len(b.cases) {
+					i = b.cases[r]
+				}
+			case 4:
+				return v0
+			}
+		}
+		return v0
+	}
+	fn := func(nm string, a []blk) *ir.FunctionDefinition {
+		var body []ir.Operation
+		for i := 0; i < 2; i++ {
+			body = append(body, &ir.VariableDeclaration{Index: i, NameID: ir.NameID(dict.SID(fmt.Sprint("v", i))), TypeID: i32, Value: &ir.Int32Value{}})
+		}
+		for i, b := range a {
+			body = append(body, &ir.Label{Number: i + 1})
+			body = append(body, set(0, c(3), v(0), &ir.Mul{TypeID: i32}, c(int32(i+1)), &ir.Add{TypeID: i32})...)
+			body = append(body, set(1, v(1), c(1), &ir.Add{TypeID: i32})...)
+			body = append(body, v(1), c(40), &ir.Gt{TypeID: i32}, &ir.Jnz{Number: blocks + 1})
+			switch b.kind {
+			case 1:
+				body = append(body, &ir.Jmp{Number: b.to})
+			case 2:
+				body = append(body, v(0), n, &ir.Add{TypeID: i32}, c(1), &ir.And{TypeID: i32}, &ir.Jnz{Number: b.to})
+			case 3:
+				x := &ir.Switch{TypeID: i32, Default: ir.Label{Number: b.to}}
+				for j, v := range b.cases {
+					x.Values = append(x.Values, &ir.Int32Value{Value: int32(j)})
+					x.Labels = append(x.Labels, ir.Label{Number: v})
+				}
+				body = append(body, v(0), n, &ir.Add{TypeID: i32}, c(4), &ir.Rem{TypeID: i32}, x)
+			case 4:
+				body = append(append(body, ret...), &ir.Return{})
+			}
+		}
+		body = append(append(body, &ir.Label{Number: blocks + 1}), ret...)
+		f := fnDef(nm, "func(int32)int32", 10, body...)
+		f.Arguments = []ir.NameID{ir.NameID(dict.SID("n"))}
+		return f
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	var obj []ir.Object
+	var main, exp bytes.Buffer
+	for i := 0; i < funcs; i++ {
+		a := make([]blk, blocks)
+		for j := range a {
+			b := &a[j]
+			b.kind = rng.Intn(5)
+			b.to = rng.Intn(blocks+1) + 1
+			if b.kind == 3 {
+				for k := rng.Intn(3); k >= 0; k-- {
+					b.cases = append(b.cases, rng.Intn(blocks+1)+1)
+				}
+			}
+		}
+		nm := fmt.Sprint("f", i)
+		obj = append(obj, fn(nm, a))
+		fmt.Fprintf(&main, "for i := int32(0); i < 6; i++ {\nfmt.Print(X%s(tls, i), \" \")\n}\nfmt.Println()\n", nm)
+		for j := int32(0); j < 6; j++ {
+			fmt.Fprint(&exp, eval(a, j), " ")
+		}
+		exp.WriteString("\n")
+	}
+	if g, e := run(t, obj, main.String()), exp.String(); g != e {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+}
+
+func TestComputedGoto(t *testing.T) {
+	i32 := ir.TypeID(dict.SID("int32"))
+	pi32 := ir.TypeID(dict.SID("*int32"))
+	vp := ir.TypeID(dict.SID("*struct{}"))
+	pvp := ir.TypeID(dict.SID("**struct{}"))
+	a := ir.NameID(dict.SID("a"))
+	b := ir.NameID(dict.SID("b"))
+	set := func(l ir.NameID) []ir.Operation {
+		return []ir.Operation{
+			&ir.Variable{Address: true, Index: 1, TypeID: pvp},
+			&ir.Const{TypeID: vp, Value: &ir.AddressValue{Label: l, Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID("f"))}},
+			&ir.Store{TypeID: vp},
+			&ir.Drop{TypeID: vp},
+		}
+	}
+	var body []ir.Operation
+	for _, v := range [][]ir.Operation{
+		{
+			&ir.VariableDeclaration{Index: 0, NameID: ir.NameID(dict.SID("s")), TypeID: i32},
+			&ir.VariableDeclaration{Index: 1, NameID: ir.NameID(dict.SID("p")), TypeID: vp},
+			&ir.Variable{Address: true, Index: 0, TypeID: pi32}, &ir.Const32{TypeID: i32}, &ir.Store{TypeID: i32}, &ir.Drop{TypeID: i32},
+		},
+		set(a),
+		{&ir.Label{Number: 1}, &ir.Variable{Index: 1, TypeID: vp}, &ir.JmpP{}, &ir.Label{NameID: a}},
+		{
+			&ir.Variable{Address: true, Index: 0, TypeID: pi32}, &ir.Variable{Index: 0, TypeID: i32}, &ir.Const32{TypeID: i32, Value: 1},
+			&ir.Add{TypeID: i32}, &ir.Store{TypeID: i32}, &ir.Drop{TypeID: i32},
+		},
+		set(b),
+		{&ir.Jmp{Number: 1}, &ir.Label{NameID: b}, &ir.Result{Address: true, TypeID: pi32}, &ir.Variable{Index: 0, TypeID: i32}},
+		{&ir.Store{TypeID: i32}, &ir.Drop{TypeID: i32}, &ir.Return{}},
+	} {
+		body = append(body, v...)
+	}
+	var buf bytes.Buffer
+	if err := New(&buf, []ir.Object{fnDef("f", "func()int32", 10, body...)}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := buf.String(), `	_p = unsafe.Pointer(unsafe.Pointer(&labels0[0]))
+	for {
+		switch int64(crt.P2U(_p)) - int64(crt.P2U(unsafe.Pointer(&labels0[0]))) {
+		case int64(0):
+			_s = _s + int32(1)
+			_p = unsafe.Pointer(unsafe.Pointer(&labels0[1]))
+		default:
+			return _s
+		}
+	}
+`; !strings.Contains(g, e) {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+}
+
+func TestSpill(t *testing.T) {
+	i32 := ir.TypeID(dict.SID("int32"))
+	pi32 := ir.TypeID(dict.SID("*int32"))
+	f := fnDef("f", "func(int32)int32", 10,
+		&ir.VariableDeclaration{Index: 0, NameID: ir.NameID(dict.SID("x")), TypeID: i32},
+		&ir.Variable{Address: true, Index: 0, TypeID: pi32},
+		&ir.Argument{TypeID: i32},
+		&ir.Jz{Number: 1},
+		&ir.Const32{TypeID: i32, Value: 10},
+		&ir.Jmp{Number: 2},
+		&ir.Label{Number: 1},
+		&ir.Const32{TypeID: i32, Value: 20},
+		&ir.Label{Number: 2},
+		&ir.Store{TypeID: i32},
+		&ir.Drop{TypeID: i32},
+		&ir.Result{Address: true, TypeID: pi32},
+		&ir.Variable{Index: 0, TypeID: i32},
+		&ir.Store{TypeID: i32},
+		&ir.Drop{TypeID: i32},
+		&ir.Return{},
+	)
+	f.Arguments = []ir.NameID{ir.NameID(dict.SID("n"))}
+	var buf bytes.Buffer
+	if err := New(&buf, []ir.Object{f}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := buf.String(), `func Xf(tls *crt.TLS, _n int32) (r0 int32) {
+	var _x, _3 int32
+	var _1, _2 *int32
+	_1 = &_x
+	if _n != 0 {
+		_2 = _1
+		_3 = int32(10)
+	} else {
+		_2 = _1
+		_3 = int32(20)
+	}
+	*_2 = _3
+	return _x
+}
+`; !strings.Contains(g, e) {
+		t.Fatalf("got\n%s\nexp\n%s", g, e)
+	}
+}
+
+func TestNull(t *testing.T) {
+	i32 := ir.TypeID(dict.SID("int32"))
+	pi32 := ir.TypeID(dict.SID("*int32"))
+	ppi32 := ir.TypeID(dict.SID("**int32"))
+	pfn := ir.TypeID(dict.SID("*func()"))
+	fp := &ir.DataDefinition{
+		ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID("fp")), TypeID: pfn},
+	}
+	tab := &ir.DataDefinition{
+		ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID("tab")), TypeID: ir.TypeID(dict.SID("[2]*func(*int32)int32"))},
+		Value:      &ir.CompositeValue{Values: []ir.Value{&ir.Int32Value{}, &ir.AddressValue{Index: 2, Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID("f"))}}},
+	}
+	f := fnDef("f", "func(*int32)int32", 10,
+		&ir.Global{Address: true, NameID: ir.NameID(dict.SID("fp")), TypeID: ir.TypeID(dict.SID("**func()")), Linkage: ir.ExternalLinkage},
+		&ir.Const32{TypeID: pfn},
+		&ir.Store{TypeID: pfn},
+		&ir.Drop{TypeID: pfn},
+		&ir.Argument{Address: true, TypeID: ppi32},
+		&ir.Nil{TypeID: pi32},
+		&ir.Store{TypeID: pi32},
+		&ir.Drop{TypeID: pi32},
+		&ir.Result{Address: true, TypeID: pi32},
+		&ir.Argument{TypeID: pi32},
+		&ir.Const32{TypeID: pi32},
+		&ir.Eq{TypeID: pi32},
+		&ir.Store{TypeID: i32},
+		&ir.Drop{TypeID: i32},
+	)
+	f.Arguments = []ir.NameID{ir.NameID(dict.SID("p"))}
+	var buf bytes.Buffer
+	if err := New(&buf, []ir.Object{fp, tab, f}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	g := buf.String()
+	for _, e := range []string{
+		"Xtab = [2]func(*crt.TLS, *int32) int32{(func(*crt.TLS, *int32) int32)(nil), Xf}",
+		`func Xf(tls *crt.TLS, _p *int32) (r0 int32) {
+	Xfp = (func(*crt.TLS))(nil)
+	bug20530(Xfp)
+	_p = (*int32)(nil)
+	return bool2int(_p == (*int32)(nil))
+}
+`,
+	} {
+		if !strings.Contains(g, e) {
+			t.Fatalf("got\n%s\nexp\n%s", g, e)
+		}
+	}
+}
+
+func TestComplex(t *testing.T) {
+	c64 := ir.TypeID(dict.SID("complex64"))
+	c128 := ir.TypeID(dict.SID("complex128"))
+	f64 := ir.TypeID(dict.SID("float64"))
+	pf64 := ir.TypeID(dict.SID("*float64"))
+	cimag := &ir.FunctionDefinition{
+		ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID("cimag")), TypeID: ir.TypeID(dict.SID("func(complex128)float64"))},
+		Body:       []ir.Operation{&ir.Panic{}},
+	}
+	c := &ir.DataDefinition{
+		ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID("c")), TypeID: ir.TypeID(dict.SID("[2]complex64"))},
+		Value:      &ir.CompositeValue{Values: []ir.Value{&ir.Complex64Value{Value: 1 + 2i}, &ir.Int32Value{Value: 3}}},
+	}
+	f := fnDef("f", "func(complex64)float64", 10,
+		&ir.Result{Address: true, TypeID: pf64},
+		&ir.Argument{TypeID: c64},
+		&ir.Convert{TypeID: c64, Result: c128},
+		&ir.ConstC128{TypeID: c128, Value: 0.5 - 1i},
+		&ir.Mul{TypeID: c128},
+		&ir.Call{Arguments: 1, TypeID: cimag.TypeID},
+		&ir.Argument{TypeID: c64},
+		&ir.Convert{TypeID: c64, Result: f64},
+		&ir.Add{TypeID: f64},
+		&ir.Store{TypeID: f64},
+		&ir.Drop{TypeID: f64},
+	)
+	f.Arguments = []ir.NameID{ir.NameID(dict.SID("a"))}
+	var buf bytes.Buffer
+	if err := New(&buf, []ir.Object{cimag, c, f}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	g := buf.String()
+	for _, e := range []string{
+		"Xc = [2]complex64{complex(float32(1), float32(2)), complex(float32(3), float32(0))}",
+		"return imag(complex128(_a)*complex(0.5, -1)) + float64(real(_a))",
+	} {
+		if !strings.Contains(g, e) {
+			t.Fatalf("got\n%s\nexp\n%s", g, e)
+		}
+	}
+}
+
+func TestBitField(t *testing.T) {
+	i32 := ir.TypeID(dict.SID("int32"))
+	pi32 := ir.TypeID(dict.SID("*int32"))
+	u16 := ir.TypeID(dict.SID("uint16"))
+	pu16 := ir.TypeID(dict.SID("*uint16"))
+	f := fnDef("f", "func(*uint16)int32", 10,
+		&ir.Argument{TypeID: pu16},
+		&ir.Dup{TypeID: pu16},
+		&ir.Load{TypeID: pu16},
+		&ir.Const32{TypeID: i32, Value: 3},
+		&ir.Rsh{TypeID: u16},
+		&ir.Const32{TypeID: u16, Value: 2},
+		&ir.Add{TypeID: u16},
+		&ir.Store{TypeID: u16, Bits: 5, BitOffset: 3},
+		&ir.Drop{TypeID: u16},
+		&ir.Argument{TypeID: pu16},
+		&ir.PostIncrement{TypeID: u16, BitFieldType: i32, Bits: 5, BitOffset: 3, Delta: 1},
+		&ir.Drop{TypeID: i32},
+		&ir.Result{Address: true, TypeID: pi32},
+		&ir.Argument{TypeID: pu16},
+		&ir.PreIncrement{TypeID: u16, BitFieldType: i32, Bits: 5, BitOffset: 3, Delta: -1},
+		&ir.Store{TypeID: i32},
+		&ir.Drop{TypeID: i32},
+	)
+	f.Arguments = []ir.NameID{ir.NameID(dict.SID("p"))}
+	var buf bytes.Buffer
+	if err := New(&buf, []ir.Object{f}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	g := buf.String()
+	for _, e := range []string{
+		`func Xf(tls *crt.TLS, _p *uint16) (r0 int32) {
+	{
+		p := _p
+		storebits0(p, ((*p)>>3)+uint16(2), 248, 3, 11)
+	}
+	postbits0(_p, 1, 248, 3, 11)
+	return int32(prebits0(_p, -1, 248, 3, 11)) << 27 >> 27
+}
+`,
+		"func postbits0(p *uint16, d int, m uint64, o, s uint) uint16 {",
+		"func storebits0(p *uint16, v uint16, m uint64, o, s uint) uint16 {",
+	} {
+		if !strings.Contains(g, e) {
+			t.Fatalf("got\n%s\nexp\n%s", g, e)
+		}
+	}
+}
+
+func TestSignedBitField(t *testing.T) {
+	i16 := ir.TypeID(dict.SID("int16"))
+	i32 := ir.TypeID(dict.SID("int32"))
+	pi32 := ir.TypeID(dict.SID("*int32"))
+	u16 := ir.TypeID(dict.SID("uint16"))
+	ps := ir.TypeID(dict.SID("*struct{a uint16,b int16}"))
+	// Both fields hold a 5 bit field at bit 3 having the value -3.
+	s := &ir.DataDefinition{
+		ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID("s")), TypeID: ir.TypeID(dict.SID("struct{a uint16,b int16}"))},
+		Value:      &ir.CompositeValue{Values: []ir.Value{&ir.Int32Value{Value: 0xe8}, &ir.Int32Value{Value: 0xe8}}},
+	}
+	glob := &ir.Global{Address: true, NameID: s.NameID, TypeID: ps, Linkage: ir.ExternalLinkage}
+	inc := fnDef("inc", "func()int32", 10,
+		&ir.Result{Address: true, TypeID: pi32},
+		glob,
+		&ir.Field{Address: true, Index: 0, TypeID: ps},
+		&ir.PreIncrement{TypeID: u16, BitFieldType: i32, Bits: 5, BitOffset: 3, Delta: 1},
+		&ir.Store{TypeID: i32},
+		&ir.Drop{TypeID: i32},
+	)
+	load := fnDef("load", "func()int32", 20,
+		&ir.Result{Address: true, TypeID: pi32},
+		glob,
+		&ir.Field{Index: 1, TypeID: ps},
+		&ir.Const32{TypeID: i32, Value: 8},
+		&ir.Lsh{TypeID: i16},
+		&ir.Const32{TypeID: i32, Value: 11},
+		&ir.Rsh{TypeID: i16},
+		&ir.Convert{TypeID: i16, Result: i32},
+		&ir.Store{TypeID: i32},
+		&ir.Drop{TypeID: i32},
+	)
+	if g, e := run(t, []ir.Object{s, inc, load}, "fmt.Println(Xinc(tls), Xload(tls), Xs.Xa)"), "-2 -3 240\n"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	u8 := ir.TypeID(dict.SID("uint8"))
+	pu8 := ir.TypeID(dict.SID("*uint8"))
+	f64 := ir.TypeID(dict.SID("float64"))
+	pf64 := ir.TypeID(dict.SID("*float64"))
+	ppf64 := ir.TypeID(dict.SID("**float64"))
+	f := fnDef("f", "func(*uint8,**float64)float64", 10,
+		&ir.Argument{Index: 1, TypeID: ppf64},
+		&ir.PostIncrement{TypeID: pf64, Delta: -8},
+		&ir.Drop{TypeID: pf64},
+		&ir.Argument{TypeID: pu8},
+		&ir.PostIncrement{TypeID: u8, Delta: -1},
+		&ir.Drop{TypeID: u8},
+		&ir.Result{Address: true, TypeID: pf64},
+		&ir.Argument{Index: 1, TypeID: ppf64},
+		&ir.Load{TypeID: ppf64},
+		&ir.PreIncrement{TypeID: f64, Delta: 1},
+		&ir.Store{TypeID: f64},
+		&ir.Drop{TypeID: f64},
+	)
+	f.Arguments = []ir.NameID{ir.NameID(dict.SID("p")), ir.NameID(dict.SID("q"))}
+	var buf bytes.Buffer
+	if err := New(&buf, []ir.Object{f}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	g := buf.String()
+	for _, e := range []string{
+		`func Xf(tls *crt.TLS, _p *uint8, _q **float64) (r0 float64) {
+	*(*uintptr)(unsafe.Pointer(unsafe.Pointer(_q))) -= 8
+	*_p -= 1
+	return preInc0(*_q, 1)
+}
+`,
+		"func preInc0(p *float64, d float64) float64 { v := *p + d; *p = v; return v }",
+	} {
+		if !strings.Contains(g, e) {
+			t.Fatalf("got\n%s\nexp\n%s", g, e)
+		}
+	}
+}
+
+func TestLongDouble(t *testing.T) {
+	f32 := ir.TypeID(dict.SID("float32"))
+	f128 := ir.TypeID(dict.SID("float128"))
+	c256 := ir.TypeID(dict.SID("complex256"))
+	d := &ir.DataDefinition{
+		ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID("d")), Position: token.Position{Filename: "test.c", Line: 10}, TypeID: ir.TypeID(dict.SID("[2]float128"))},
+		Value:      &ir.CompositeValue{Values: []ir.Value{&ir.Float64Value{Value: 0.5}, &ir.Int32Value{Value: 3}}},
+	}
+	f := fnDef("f", "func(float128,complex256)float32", 20,
+		&ir.Result{Address: true, TypeID: ir.TypeID(dict.SID("*float32"))},
+		&ir.Argument{TypeID: f128},
+		&ir.Const64{TypeID: f128, Value: int64(math.Float64bits(2.5))},
+		&ir.Mul{TypeID: f128},
+		&ir.Argument{Index: 1, TypeID: c256},
+		&ir.Convert{TypeID: c256, Result: f128},
+		&ir.Add{TypeID: f128},
+		&ir.Convert{TypeID: f128, Result: f32},
+		&ir.Store{TypeID: f32},
+		&ir.Drop{TypeID: f32},
+	)
+	f.Arguments = []ir.NameID{ir.NameID(dict.SID("a")), ir.NameID(dict.SID("b"))}
+	g := fnDef("g", "func()", 30)
+	var buf bytes.Buffer
+	var w []*Diagnostic
+	if err := New(&buf, []ir.Object{d, f, g}, nil, Warnings(func(d *Diagnostic) { w = append(w, d) })); err != nil {
+		t.Fatal(err)
+	}
+
+	s := buf.String()
+	for _, e := range []string{
+		"Xd = [2]float64{0.5, float64(3)}",
+		`func Xf(tls *crt.TLS, _a float64, _b complex128) (r0 float32) {
+	return float32((_a * 2.5) + float64(real(_b)))
+}
+`,
+	} {
+		if !strings.Contains(s, e) {
+			t.Fatalf("got\n%s\nexp\n%s", s, e)
+		}
+	}
+	if g, e := len(w), 2; g != e {
+		t.Fatalf("got %v, expected %v\n%v", g, e, w)
+	}
+
+	for i, v := range []int{10, 20} {
+		if g, e := w[i].Position.Line, v; g != e {
+			t.Errorf("got %v, expected %v", g, e)
+		}
+	}
+	m, err := ir.NewMemoryModel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := lowerLongDouble(m).Sizeof(ir.TypeCache{}.MustType(f128)), int64(8); g != e {
+		t.Fatalf("got %v, expected %v", g, e)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	m := ir.MemoryModel{}
+	for k, v := range model64 {
+		m[k] = v
+	}
+	m[ir.Int16] = ir.MemoryModelItem{Align: 2, Size: 2, StructAlign: 4}
+	model := func(o *options) error { o.model = m; return nil }
+	d := &ir.DataDefinition{
+		ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID("d")), TypeID: ir.TypeID(dict.SID("struct{a int8,b int16,c int64}"))},
+	}
+	i64 := ir.TypeID(dict.SID("int64"))
+	pd := ir.TypeID(dict.SID("*struct{a int8,b int16,c int64}"))
+	f := fnDef("f", "func()", 10,
+		&ir.Global{Address: true, NameID: d.NameID, TypeID: pd, Linkage: ir.ExternalLinkage},
+		&ir.Field{Address: true, Index: 2, TypeID: pd},
+		&ir.Const64{TypeID: i64, Value: 1},
+		&ir.Store{TypeID: i64},
+		&ir.Drop{TypeID: i64},
+	)
+	var buf bytes.Buffer
+	if err := New(&buf, []ir.Object{d, f}, nil, model); err != nil {
+		t.Fatal(err)
+	}
+
+	g := buf.String()
+	for _, e := range []string{
+		`type t0 struct {
+	Xa int8
+	_  [2]byte
+	Xb int16
+	Xc int64
+} // struct{a int8,b int16,c int64}
+var _ = [1]struct{}{}[unsafe.Offsetof(t0{}.Xb)-4]
+var _ = [1]struct{}{}[unsafe.Offsetof(t0{}.Xc)-8]
+var _ = [1]struct{}{}[unsafe.Sizeof(t0{})-16]
+`,
+	} {
+		if !strings.Contains(g, e) {
+			t.Fatalf("got\n%s\nexp\n%s", g, e)
+		}
+	}
+
+	// The offset assertions must compile.
+	if g, e := run(t, []ir.Object{d, f}, "Xf(tls)\nfmt.Println(Xd.Xc)", model), "1\n"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+
+	m[ir.Int64] = ir.MemoryModelItem{Align: 8, Size: 8, StructAlign: 2}
+	buf.Reset()
+	if err := New(&buf, []ir.Object{d, f}, nil, model); err != nil {
+		t.Fatal(err)
+	}
+
+	g = buf.String()
+	for _, e := range []string{
+		"*Xd.Xc() = int64(1)",
+		"type t0 [16]byte",
+		"func (p *t0) Xb() *int16 { return (*int16)(unsafe.Pointer(&p[4])) }",
+		"func (p *t0) Xc() *int64 { return (*int64)(unsafe.Pointer(&p[6])) }",
+	} {
+		if !strings.Contains(g, e) {
+			t.Fatalf("got\n%s\nexp\n%s", g, e)
+		}
+	}
+
+	if g, e := run(t, []ir.Object{d, f}, "Xf(tls)\nfmt.Println(*Xd.Xc(), Xd[6])", model), "1 1\n"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+}
+
+func TestUnionAccessors(t *testing.T) {
+	i32 := ir.TypeID(dict.SID("int32"))
+	pi32 := ir.TypeID(dict.SID("*int32"))
+	f32 := ir.TypeID(dict.SID("float32"))
+	pu := ir.TypeID(dict.SID("*union{a int32,b float32}"))
+	u := &ir.DataDefinition{
+		ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: ir.NameID(dict.SID("u")), TypeID: ir.TypeID(dict.SID("union{a int32,b float32}"))},
+	}
+	f := fnDef("f", "func()int32", 10,
+		&ir.Global{Address: true, NameID: u.NameID, TypeID: pu, Linkage: ir.ExternalLinkage},
+		&ir.Field{Address: true, Index: 1, TypeID: pu},
+		&ir.Const32{TypeID: f32, Value: int32(math.Float32bits(1))},
+		&ir.Store{TypeID: f32},
+		&ir.Drop{TypeID: f32},
+		&ir.Result{Address: true, TypeID: pi32},
+		&ir.Global{Address: true, NameID: u.NameID, TypeID: pu, Linkage: ir.ExternalLinkage},
+		&ir.Field{Index: 0, TypeID: pu},
+		&ir.Store{TypeID: i32},
+		&ir.Drop{TypeID: i32},
+	)
+	var buf bytes.Buffer
+	if err := New(&buf, []ir.Object{u, f}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	g := buf.String()
+	for _, e := range []string{
+		`func Xf(tls *crt.TLS) (r0 int32) {
+	*Xu.Xb() = float32(1)
+	return *Xu.Xa()
+}
+`,
+		"func (p *t0) Xa() *int32   { return (*int32)(unsafe.Pointer(p)) }",
+		"func (p *t0) Xb() *float32 { return (*float32)(unsafe.Pointer(p)) }",
+	} {
+		if !strings.Contains(g, e) {
+			t.Fatalf("got\n%s\nexp\n%s", g, e)
+		}
+	}
+}
+
+func TestUnionInit(t *testing.T) {
+	nm := func(s string) ir.NameID { return ir.NameID(dict.SID(s)) }
+	i32 := ir.TypeID(dict.SID("int32"))
+	x := &ir.DataDefinition{
+		ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: nm("x"), TypeID: i32},
+	}
+	u := &ir.DataDefinition{
+		ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: nm("u"), TypeID: ir.TypeID(dict.SID("union{a int32,b [2]int16}"))},
+		Value: &ir.CompositeValue{Values: []ir.Value{
+			&ir.DesignatedValue{Index: 1, Value: &ir.CompositeValue{Values: []ir.Value{&ir.DesignatedValue{Index: 1, Value: &ir.Int32Value{Value: 0x102}}}}},
+		}},
+	}
+	v := &ir.DataDefinition{
+		ObjectBase: ir.ObjectBase{Linkage: ir.ExternalLinkage, NameID: nm("v"), TypeID: ir.TypeID(dict.SID("union{a int32,b *int32}"))},
+		Value: &ir.CompositeValue{Values: []ir.Value{
+			&ir.DesignatedValue{Index: 1, Value: &ir.AddressValue{Linkage: ir.ExternalLinkage, NameID: x.NameID}},
+		}},
+	}
+	var buf bytes.Buffer
+	if err := New(&buf, []ir.Object{x, u, v}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	g := buf.String()
+	for _, e := range []string{
+		"Xu = t0{U: [4]byte{0, 0, 2, 1}}",
+		`Xv = func() (r t1) {
+		*r.Xb() = &Xx
+		return r
+	}()`,
+	} {
+		if !strings.Contains(g, e) {
+			t.Fatalf("got\n%s\nexp\n%s", g, e)
+		}
+	}
+
+	if g, e := run(t, []ir.Object{x, u, v}, "fmt.Println(*Xu.Xb(), *Xv.Xb() == &Xx)"), "[0 258] true\n"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+}
+
+func TestFlexibleArray(t *testing.T) {
+	i32 := ir.TypeID(dict.SID("int32"))
+	pi32 := ir.TypeID(dict.SID("*int32"))
+	ps := ir.TypeID(dict.SID("*struct{c int8,a [0]int32}"))
+	f := fnDef("f", "func(*struct{c int8,a [0]int32},int32)int32", 10,
+		&ir.Result{Address: true, TypeID: pi32},
+		&ir.Argument{Index: 0, TypeID: ps},
+		&ir.Field{Address: true, Index: 1, TypeID: ps},
+		&ir.Convert{TypeID: ir.TypeID(dict.SID("*[0]int32")), Result: pi32},
+		&ir.Argument{Index: 1, TypeID: i32},
+		&ir.Element{IndexType: i32, TypeID: pi32},
+		&ir.Store{TypeID: i32},
+		&ir.Drop{TypeID: i32},
+	)
+	f.Arguments = []ir.NameID{ir.NameID(dict.SID("p")), ir.NameID(dict.SID("i"))}
+	var buf bytes.Buffer
+	if err := New(&buf, []ir.Object{f}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	g := buf.String()
+	for _, e := range []string{
+		"return *elem1((*int32)(unsafe.Pointer(_p.Xa())), uintptr(_i))",
+		`type t0 struct {
+	Xc int8
+	_  [3]byte
+}`,
+		"func (p *t0) Xa() *[0]int32 { return (*[0]int32)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + 4)) }",
+		"var _ = [1]struct{}{}[unsafe.Sizeof(t0{})-4]",
+	} {
+		if !strings.Contains(g, e) {
+			t.Fatalf("got\n%s\nexp\n%s", g, e)
+		}
+	}
+
+	// The object is allocated like C does, sizeof(struct)+n*sizeof(int32)
+	// bytes, and a[3] is read past the declared length.
+	main := `b := make([]int32, 1+4)
+for i := range b {
+	b[i] = int32(10 * i)
+}
+fmt.Println(Xf(tls, (*t0)(unsafe.Pointer(&b[0])), 3))`
+	if g, e := run(t, []ir.Object{f}, main), "40\n"; g != e {
+		t.Fatalf("got %q, expected %q", g, e)
+	}
+}
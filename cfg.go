@@ -0,0 +1,967 @@
+// Copyright 2017 The IRGO Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package irgo
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/cznic/internal/buffer"
+	"github.com/cznic/ir"
+)
+
+// block is a basic block of a function.
+type block struct {
+	edges  []*block    // Distinct successors.
+	fwd    int         // Number of forward edges entering the block.
+	id     int         // Index in cfg.blocks.
+	idom   *block      // Immediate dominator.
+	kids   []*block    // Placed blocks written after this one, in reverse postorder.
+	label  *ir.Label   // Leading label, if any.
+	loop   *loop       // Innermost loop containing the block.
+	next   *block      // Fall through successor.
+	ops    []operation // Statements.
+	parent *block      // Block the code of which contains this one.
+	placed bool        // Written as a kid of its placement parent.
+	preds  []*block
+	rpo    int      // Reverse postorder number, -1 for unreachable blocks.
+	succs  []*block // Branch targets. Jz, Jnz: target, next.
+	term   operation
+}
+
+// empty reports whether entering b only transfers control to its single
+// successor.
+func (b *block) empty() bool {
+	if len(b.succs) != 1 {
+		return false
+	}
+
+	switch b.term.(type) {
+	case nil, *ir.Jmp:
+		// ok
+	default:
+		return false
+	}
+
+	return b.quiet()
+}
+
+// quiet reports whether b has no statements producing code.
+func (b *block) quiet() bool {
+	for _, v := range b.ops {
+		switch x := v.(type) {
+		case
+			*ir.AllocResult,
+			*ir.Arguments,
+			*ir.BeginScope,
+			*ir.EndScope:
+
+			// nop
+		case *ir.VariableDeclaration:
+			if x.Value != nil {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// loop is a natural loop.
+type loop struct {
+	blocks map[*block]struct{}
+	header *block
+	parent *loop
+}
+
+func (l *loop) has(b *block) bool {
+	_, ok := l.blocks[b]
+	return ok
+}
+
+// construct is a for or switch statement being written.
+type construct struct {
+	breaks int    // Number of break statements referring to the construct.
+	follow *block // Block control reaches after the statement.
+	loop   *block // Loop header, nil for a switch statement.
+}
+
+// cfg reconstructs the structured control flow of a function from its graph.
+//
+// Blocks are written in a tree. The parent of a block is its immediate
+// dominator unless the block is outside of a loop containing the dominator,
+// in which case the parent is the header of the outermost such loop. A block
+// having a single forward predecessor and its dominator for parent is written
+// inline at the branch to it. Other blocks are placed: they are written after
+// the code of their parent and reached by falling through, break, continue or
+// goto. Loop headers become for statements, back edges continue statements.
+// The condition of a header only deciding whether to exit the loop becomes
+// the condition of the for statement. The targets of a switch become its
+// clauses, falling through to the next one where possible.
+//
+// A cycle entered at more than one block is irreducible and cannot be written
+// using for statements. The blocks where such cycle is entered become roots.
+// The roots are written one after another at the top level of the function,
+// where goto can reach them from anywhere, and every other block is written
+// in the tree of the single root dominating it. Only the edges entering the
+// roots become gotos, the rest of the function keeps its structure.
+type cfg struct {
+	*gen
+	blocks  []*block
+	entry   *block
+	gotos   map[*block]int
+	loops   map[*block]*loop // Header: loop.
+	roots   []*block         // Entry first.
+	rpo     []*block
+	stack   []*construct
+	targets map[int]*block // Label: block.
+	used    map[*block]struct{}
+	void    bool
+}
+
+// structured writes the function body represented by nodes using Go control
+// flow statements. It reports whether the written code ends with a terminating
+// statement.
+func (g *gen) structured(nodes []*node, void bool) (term bool) {
+	c := &cfg{
+		gen:     g,
+		gotos:   map[*block]int{},
+		loops:   map[*block]*loop{},
+		targets: map[int]*block{},
+		used:    map[*block]struct{}{},
+		void:    void,
+	}
+	c.build(nodes)
+	c.analyze()
+	c.place()
+	out := g.out
+	g.out = &buffer.Bytes{}
+	for i, v := range c.roots {
+		var fall *block
+		if i+1 < len(c.roots) {
+			fall = c.roots[i+1]
+		}
+		_, term = c.tree(v, fall)
+	}
+	b := g.out
+	g.out = out
+	c.labels(b.Bytes())
+	b.Close()
+	return term
+}
+
+func (c *cfg) newBlock() *block {
+	b := &block{id: len(c.blocks), rpo: -1}
+	c.blocks = append(c.blocks, b)
+	return b
+}
+
+func (c *cfg) target(nm ir.NameID, n int) *block { return c.targets[label2(nm, n)] }
+
+// build splits nodes into basic blocks and connects them. Empty blocks are
+// bypassed.
+func (c *cfg) build(nodes []*node) {
+	first := map[*node]*block{}
+	last := make([]*block, len(nodes))
+	for i, n := range nodes {
+		b := c.newBlock()
+		first[n] = b
+		for _, op := range n.Ops {
+			switch x := op.(type) {
+			case *ir.Label:
+				b.label = x
+				c.targets[label(x)] = b
+			case *expr:
+				switch x.Expr.Op.(type) {
+				case
+					*ir.Jnz,
+					*ir.Jz:
+
+					b.term = x
+					nb := c.newBlock()
+					b.next = nb
+					b = nb
+				case *ir.Switch:
+					b.term = x
+				default:
+					b.ops = append(b.ops, x)
+				}
+			case
+				*ir.Jmp,
+				*ir.Return:
+
+				b.term = x
+			default:
+				b.ops = append(b.ops, x)
+			}
+		}
+		last[i] = b
+	}
+	for i, n := range nodes {
+		last[i].next = first[n.Fallthrough]
+	}
+
+	for _, b := range c.blocks {
+		switch x := b.term.(type) {
+		case nil:
+			if b.next != nil {
+				b.succs = []*block{b.next}
+			}
+		case *ir.Jmp:
+			b.succs = []*block{c.target(x.NameID, x.Number)}
+		case *ir.Return:
+			// nop
+		case *expr:
+			switch y := x.Expr.Op.(type) {
+			case *ir.Jnz:
+				b.succs = []*block{c.target(y.NameID, y.Number), b.next}
+			case *ir.Jz:
+				b.succs = []*block{c.target(y.NameID, y.Number), b.next}
+			case *ir.Switch:
+				for _, v := range y.Labels {
+					b.succs = append(b.succs, c.target(v.NameID, v.Number))
+				}
+				b.succs = append(b.succs, c.target(y.Default.NameID, y.Default.Number))
+			}
+		}
+		for _, v := range b.succs {
+			if v == nil {
+				c.unsupported(b.term.Pos(), b.term, "branch to an undefined label")
+			}
+		}
+	}
+
+	skip := make([]*block, len(c.blocks))
+	for i, b := range c.blocks {
+		for j := 0; j < len(c.blocks) && b.empty(); j++ {
+			b = b.succs[0]
+		}
+		skip[i] = b
+	}
+	for k, v := range c.targets {
+		c.targets[k] = skip[v.id]
+	}
+	for _, b := range c.blocks {
+		m := map[*block]struct{}{}
+		for i, v := range b.succs {
+			v = skip[v.id]
+			b.succs[i] = v
+			if _, ok := m[v]; !ok {
+				m[v] = struct{}{}
+				b.edges = append(b.edges, v)
+			}
+		}
+	}
+	c.entry = skip[0]
+}
+
+// analyze computes the reverse postorder, the roots, dominators and natural
+// loops of the graph.
+func (c *cfg) analyze() {
+	var post []*block
+	seen := map[*block]struct{}{}
+	var dfs func(*block)
+	dfs = func(b *block) {
+		seen[b] = struct{}{}
+		a := append([]*block(nil), b.edges...)
+		sort.Slice(a, func(i, j int) bool { return a[i].id > a[j].id })
+		for _, v := range a {
+			if _, ok := seen[v]; !ok {
+				dfs(v)
+			}
+		}
+		post = append(post, b)
+	}
+	dfs(c.entry)
+	for i := len(post) - 1; i >= 0; i-- {
+		b := post[i]
+		b.rpo = len(c.rpo)
+		c.rpo = append(c.rpo, b)
+	}
+	for _, b := range c.rpo {
+		for _, v := range b.edges {
+			v.preds = append(v.preds, b)
+		}
+	}
+
+	// The roots are the successors of a virtual block, which becomes the
+	// immediate dominator of the blocks reached from more than one root.
+	// Those become roots as well as the targets of retreating edges not
+	// dominating their sources, until the blocks of every root form a
+	// reducible graph.
+	root := map[*block]bool{c.entry: true}
+	if unstructured {
+		for _, b := range c.rpo {
+			root[b] = true
+		}
+	}
+	top := &block{rpo: -1}
+	top.idom = top
+	for n := 0; n != len(root); {
+		n = len(root)
+		for _, b := range c.rpo {
+			b.idom = nil
+			if root[b] {
+				b.idom = top
+			}
+		}
+
+		// Cooper, Harvey, Kennedy: A Simple, Fast Dominance Algorithm.
+		for changed := true; changed; {
+			changed = false
+			for _, b := range c.rpo {
+				if root[b] {
+					continue
+				}
+
+				var d *block
+				for _, p := range b.preds {
+					switch {
+					case p.idom == nil:
+						// nop
+					case d == nil:
+						d = p
+					default:
+						d = intersect(p, d)
+					}
+				}
+				if b.idom != d {
+					b.idom = d
+					changed = true
+				}
+			}
+		}
+		for _, b := range c.rpo {
+			if b.idom == top {
+				b.idom = nil
+				root[b] = true
+			}
+		}
+		for _, b := range c.rpo {
+			for _, v := range b.edges {
+				if v.rpo <= b.rpo && !dominates(v, b) {
+					root[v] = true
+				}
+			}
+		}
+	}
+	for _, b := range c.rpo {
+		if root[b] {
+			c.roots = append(c.roots, b)
+		}
+	}
+	a := c.roots[1:]
+	sort.Slice(a, func(i, j int) bool { return a[i].id < a[j].id })
+
+	latches := map[*block][]*block{}
+	for _, b := range c.rpo {
+		for _, v := range b.edges {
+			switch {
+			case dominates(v, b):
+				latches[v] = append(latches[v], b)
+			case !root[v]:
+				v.fwd++
+			}
+		}
+	}
+
+	var loops []*loop
+	for _, h := range c.rpo {
+		a := latches[h]
+		if len(a) == 0 {
+			continue
+		}
+
+		l := &loop{blocks: map[*block]struct{}{h: {}}, header: h}
+		for len(a) != 0 {
+			b := a[len(a)-1]
+			a = a[:len(a)-1]
+			if !l.has(b) {
+				l.blocks[b] = struct{}{}
+				a = append(a, b.preds...)
+			}
+		}
+		loops = append(loops, l)
+		c.loops[h] = l
+	}
+	sort.SliceStable(loops, func(i, j int) bool { return len(loops[i].blocks) > len(loops[j].blocks) })
+	for _, l := range loops {
+		l.parent = l.header.loop
+		for b := range l.blocks {
+			b.loop = l
+		}
+	}
+}
+
+func intersect(a, b *block) *block {
+	for a != b {
+		for a.rpo > b.rpo {
+			a = a.idom
+		}
+		for b.rpo > a.rpo {
+			b = b.idom
+		}
+	}
+	return a
+}
+
+// dominates reports whether a dominates b.
+func dominates(a, b *block) bool {
+	for ; b != nil; b = b.idom {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
+
+// closed reports whether control cannot leave the blocks dominated by b.
+func (c *cfg) closed(b *block) bool {
+	for _, v := range c.rpo {
+		if !dominates(b, v) {
+			continue
+		}
+
+		for _, w := range v.edges {
+			if !dominates(b, w) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// place computes the tree of blocks.
+func (c *cfg) place() {
+	for _, b := range c.rpo {
+		p := b.idom
+		if p == nil {
+			// Root.
+			b.placed = true
+			continue
+		}
+
+		b.placed = b.fwd > 1
+		if l := p.loop; l != nil && !l.has(b) && (b.fwd != 1 || c.exit(p) == b || !c.closed(b)) {
+			for ; l != nil && !l.has(b); l = l.parent {
+				p = l.header
+			}
+			b.placed = true
+		}
+		if b.placed {
+			p.kids = append(p.kids, b)
+		}
+		b.parent = p
+	}
+}
+
+// within reports whether b is written as a part of the code of a.
+func within(a, b *block) bool {
+	for ; b != nil; b = b.parent {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
+
+// exit returns the block reached when the condition of the loop with header h
+// does not hold. The header of such loop only evaluates the condition and
+// either exits the loop or continues executing it. Otherwise exit returns nil.
+func (c *cfg) exit(h *block) *block {
+	l := c.loops[h]
+	x, ok := h.term.(*expr)
+	if l == nil || !ok || x.Expr.Comma != nil || !h.quiet() {
+		return nil
+	}
+
+	switch x.Expr.Op.(type) {
+	case
+		*ir.Jnz,
+		*ir.Jz:
+
+		switch jump, next := h.succs[0], h.succs[1]; {
+		case l.has(jump) && !l.has(next):
+			return next
+		case !l.has(jump) && l.has(next):
+			return jump
+		}
+	}
+	return nil
+}
+
+// use returns the label of b and records its use.
+func (c *cfg) use(b *block) string {
+	c.used[b] = struct{}{}
+	if b.label == nil {
+		return fmt.Sprintf("_%d_", b.id)
+	}
+
+	switch n := label(b.label); {
+	case n > 0:
+		return fmt.Sprintf("_%v", ir.NameID(n))
+	default:
+		return fmt.Sprintf("_%v", -n)
+	}
+}
+
+// labels writes b to g.out replacing block markers by the used labels.
+func (c *cfg) labels(b []byte) {
+	for {
+		i := bytes.IndexByte(b, 0)
+		if i < 0 {
+			c.out.Write(b)
+			return
+		}
+
+		c.out.Write(b[:i])
+		b = b[i+1:]
+		i = bytes.IndexByte(b, 0)
+		n, err := strconv.Atoi(string(b[:i]))
+		if err != nil {
+			panic("internal error")
+		}
+
+		b = b[i+1:]
+		if blk := c.blocks[n]; blk.label != nil || blk.placed {
+			if _, ok := c.used[blk]; ok {
+				c.w("%s:\n", c.use(blk))
+			}
+		}
+	}
+}
+
+// capture returns what f writes.
+func (c *cfg) capture(f func() (bool, bool)) (b []byte, noFall, term bool) {
+	out := c.out
+	c.out = &buffer.Bytes{}
+	noFall, term = f()
+	b = append([]byte(nil), c.out.Bytes()...)
+	c.out.Close()
+	c.out = out
+	return b, noFall, term
+}
+
+// tree writes b and its kids. Control leaving the written code continues at
+// fall, nil meaning the end of the function. noFall reports that control
+// cannot reach the end of the written code, term that it ends with a
+// terminating statement.
+func (c *cfg) tree(b, fall *block) (noFall, term bool) {
+	c.w("\x00%d\x00", b.id)
+	l := c.loops[b]
+	if l == nil {
+		return c.region(b, b.kids, fall)
+	}
+
+	exit := c.exit(b)
+	var in, out []*block
+	for _, v := range b.kids {
+		switch {
+		case l.has(v):
+			in = append(in, v)
+		case v == exit:
+			out = append([]*block{v}, out...)
+		default:
+			out = append(out, v)
+		}
+	}
+	ct := &construct{follow: fall, loop: b}
+	if len(out) != 0 {
+		ct.follow = out[0]
+	}
+	c.stack = append(c.stack, ct)
+	switch {
+	case exit != nil && exit == ct.follow:
+		// Loop condition.
+		n := b.term.(*expr).Expr
+		cond := "!= 0"
+		if _, ok := n.Op.(*ir.Jz); ok == (exit == b.succs[1]) {
+			cond = "== 0"
+		}
+		stay := b.succs[0]
+		if stay == exit {
+			stay = b.succs[1]
+		}
+		c.w("for ")
+		c.expression(n.Childs[0], false)
+		c.w(" %s {\n", cond)
+		next := b
+		if len(in) != 0 {
+			next = in[0]
+		}
+		nf, tm := c.branch(b, stay, next)
+		c.trees(in, b, nf, tm)
+		noFall, term = false, false
+	default:
+		c.w("for {\n")
+		c.region(b, in, b)
+		noFall = ct.breaks == 0
+		term = noFall
+	}
+	c.w("}\n")
+	c.stack = c.stack[:len(c.stack)-1]
+	return c.trees(out, fall, noFall, term)
+}
+
+// region writes the statements and the branch of b followed by kids.
+func (c *cfg) region(b *block, kids []*block, fall *block) (noFall, term bool) {
+	c.stmts(b)
+	if x, ok := b.term.(*expr); ok {
+		if y, ok := x.Expr.Op.(*ir.Switch); ok {
+			kids, noFall, term = c.switchStmt(b, x.Expr, y, kids, fall)
+			return c.trees(kids, fall, noFall, term)
+		}
+	}
+
+	next := fall
+	if len(kids) != 0 {
+		next = kids[0]
+	}
+	noFall, term = c.branches(b, next)
+	return c.trees(kids, fall, noFall, term)
+}
+
+// trees writes blocks, each falling through to the next one and the last one
+// to fall. noFall and term describe the code preceding the blocks.
+func (c *cfg) trees(blocks []*block, fall *block, noFall, term bool) (bool, bool) {
+	for i, v := range blocks {
+		next := fall
+		if i+1 < len(blocks) {
+			next = blocks[i+1]
+		}
+		noFall, term = c.tree(v, next)
+	}
+	return noFall, term
+}
+
+func (c *cfg) stmts(b *block) {
+	for _, op := range b.ops {
+		switch x := op.(type) {
+		case *expr:
+			c.expression(x.Expr, true)
+			c.w("\n")
+		case *ir.VariableDeclaration:
+			c.varDecl(x)
+		case
+			*ir.AllocResult,
+			*ir.Arguments,
+			*ir.BeginScope,
+			*ir.EndScope:
+
+			// nop
+		default:
+			c.unsupported(x.Pos(), x, "operation")
+		}
+	}
+}
+
+// branches writes the code transferring control from b to its successors.
+func (c *cfg) branches(b, fall *block) (noFall, term bool) {
+	switch x := b.term.(type) {
+	case nil, *ir.Jmp:
+		if len(b.succs) != 0 {
+			return c.branch(b, b.succs[0], fall)
+		}
+	case *expr:
+		switch x.Expr.Op.(type) {
+		case
+			*ir.Jnz,
+			*ir.Jz:
+
+			return c.ifStmt(b, x.Expr, fall)
+		}
+	}
+
+	// Return.
+	if fall == nil && c.void {
+		return false, false
+	}
+
+	c.w("return\n")
+	return true, true
+}
+
+// branch writes the code transferring control from src to t.
+func (c *cfg) branch(src, t, fall *block) (noFall, term bool) {
+	if t == fall {
+		return false, false
+	}
+
+	if c.loops[t] != nil && dominates(t, src) {
+		inner := true
+		for i := len(c.stack) - 1; i >= 0; i-- {
+			switch ct := c.stack[i]; {
+			case ct.loop == t && inner:
+				c.w("continue\n")
+				return true, false
+			case ct.loop == t:
+				c.w("continue %s\n", c.use(t))
+				return true, false
+			case ct.loop != nil:
+				inner = false
+			}
+		}
+		panic("internal error")
+	}
+
+	for i := len(c.stack) - 1; i >= 0; i-- {
+		ct := c.stack[i]
+		if ct.follow != t {
+			continue
+		}
+
+		switch {
+		case i == len(c.stack)-1:
+			c.w("break\n")
+		case ct.loop != nil:
+			c.w("break %s\n", c.use(ct.loop))
+		default:
+			continue
+		}
+		ct.breaks++
+		return true, false
+	}
+
+	if t.placed {
+		c.gotos[t]++
+		c.w("goto %s\n", c.use(t))
+		return true, true
+	}
+
+	return c.tree(t, fall)
+}
+
+// comma writes the comma parts of n as statements.
+func (c *cfg) comma(n *exprNode) {
+	var a []*exprNode
+	for v := n.Comma; v != nil; v = v.Comma {
+		a = append(a, v)
+	}
+	for _, v := range a {
+		v.Comma = nil
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		c.expression(a[i], true)
+		c.w("\n")
+	}
+	relink(a)
+}
+
+// arm is the code of a branch.
+type arm struct {
+	b      []byte
+	cond   string
+	noFall bool
+	term   bool
+}
+
+// ifStmt writes the conditional branch ending b.
+func (c *cfg) ifStmt(b *block, n *exprNode, fall *block) (noFall, term bool) {
+	c.comma(n)
+	x, _, _ := c.capture(func() (bool, bool) {
+		c.expression(n.Childs[0], false)
+		return false, false
+	})
+	jump, next := b.succs[0], b.succs[1]
+	jumpCond, nextCond := "!= 0", "== 0"
+	if _, ok := n.Op.(*ir.Jz); ok {
+		jumpCond, nextCond = nextCond, jumpCond
+	}
+	if jump == next {
+		c.w("if %s %s {\n}\n", x, jumpCond)
+		return c.branch(b, jump, fall)
+	}
+
+	a := arm{cond: nextCond}
+	a.b, a.noFall, a.term = c.capture(func() (bool, bool) { return c.branch(b, next, fall) })
+	e := arm{cond: jumpCond}
+	e.b, e.noFall, e.term = c.capture(func() (bool, bool) { return c.branch(b, jump, fall) })
+	if len(a.b) == 0 || e.noFall && (!a.noFall || len(e.b) < len(a.b)) {
+		a, e = e, a
+	}
+	switch {
+	case len(a.b) == 0:
+		c.w("if %s %s {\n}\n", x, a.cond)
+		return false, false
+	case len(e.b) == 0:
+		c.w("if %s %s {\n%s}\n", x, a.cond, a.b)
+		return false, false
+	case a.noFall:
+		c.w("if %s %s {\n%s}\n%s", x, a.cond, a.b, e.b)
+		return e.noFall, e.term
+	default:
+		c.w("if %s %s {\n%s} else {\n%s}\n", x, a.cond, a.b, e.b)
+		return false, false
+	}
+}
+
+// switchStmt writes the switch statement ending b. Case values having the same
+// target share a case clause. The clauses are ordered by the position of their
+// targets in the function. A target placed after b and reached only from b and
+// the code written in the preceding clause is written inside its clause, the
+// preceding clause falling through to it. Such targets are removed from kids,
+// the blocks placed after b, and the remaining ones are returned.
+func (c *cfg) switchStmt(b *block, n *exprNode, x *ir.Switch, kids []*block, fall *block) (rest []*block, noFall, term bool) {
+	c.comma(n)
+	a := c.switchPairs(x)
+	dflt := c.target(x.Default.NameID, x.Default.Number)
+	var targets []*block
+	values := map[*block][]ir.Value{}
+	for _, v := range a {
+		t := c.target(v.Label.NameID, v.Label.Number)
+		if t == dflt {
+			continue
+		}
+
+		if _, ok := values[t]; !ok {
+			targets = append(targets, t)
+		}
+		values[t] = append(values[t], v.Value)
+	}
+	targets = append(targets, dflt)
+	sort.Slice(targets, func(i, j int) bool { return targets[i].id < targets[j].id })
+
+	isKid := map[*block]bool{}
+	for _, v := range kids {
+		isKid[v] = true
+	}
+	outside := map[*block]bool{}
+	for {
+		inside := c.inside(b, targets, isKid, outside)
+		rest = rest[:0]
+		for _, v := range kids {
+			if !inside[v] {
+				rest = append(rest, v)
+			}
+		}
+		follow := fall
+		if len(rest) != 0 {
+			follow = rest[0]
+		}
+
+		// A goto to a target inside a clause other than its own is
+		// invalid, in that case try again with the target outside of
+		// the switch statement.
+		used := map[*block]struct{}{}
+		for k := range c.used {
+			used[k] = struct{}{}
+		}
+		gotos := map[*block]int{}
+		for k := range inside {
+			gotos[k] = c.gotos[k]
+		}
+		var breaks []int
+		for _, v := range c.stack {
+			breaks = append(breaks, v.breaks)
+		}
+		s, noFall, term := c.capture(func() (bool, bool) {
+			return c.clauses(b, n, x, targets, values, inside, follow)
+		})
+		ok := true
+		for k := range inside {
+			if c.gotos[k] != gotos[k] {
+				outside[k] = true
+				ok = false
+			}
+		}
+		if ok {
+			c.out.Write(s)
+			return rest, noFall, term
+		}
+
+		c.used = used
+		for i, v := range breaks {
+			c.stack[i].breaks = v
+		}
+	}
+}
+
+// inside returns the targets of the switch statement ending b which are
+// written inside their clauses. Every predecessor of such target other than b
+// is written in the preceding clause, which then can fall through to it.
+func (c *cfg) inside(b *block, targets []*block, isKid, outside map[*block]bool) map[*block]bool {
+	r := map[*block]bool{}
+outer:
+	for i, t := range targets {
+		if i == 0 || !isKid[t] || outside[t] {
+			continue
+		}
+
+		// The preceding clause contains the code of its target unless
+		// the target is written elsewhere or it is a loop header
+		// continued from the clause.
+		prev := targets[i-1]
+		if !r[prev] && (prev.placed || dominates(prev, b)) {
+			continue
+		}
+
+		for _, v := range t.preds {
+			if v != b && !within(prev, v) {
+				continue outer
+			}
+		}
+		r[t] = true
+	}
+	return r
+}
+
+// clauses writes the switch statement ending b.
+func (c *cfg) clauses(b *block, n *exprNode, x *ir.Switch, targets []*block, values map[*block][]ir.Value, inside map[*block]bool, follow *block) (noFall, term bool) {
+	dflt := c.target(x.Default.NameID, x.Default.Number)
+	ct := &construct{follow: follow}
+	c.stack = append(c.stack, ct)
+	c.w("switch ")
+	c.expression(n.Childs[0], false)
+	c.w(" {\n")
+	noFall, term = true, true
+	hasDefault := false
+	for i, t := range targets {
+		next := follow
+		if i+1 < len(targets) && inside[targets[i+1]] {
+			next = targets[i+1]
+		}
+		body, nf, tm := c.capture(func() (bool, bool) {
+			if inside[t] {
+				return c.tree(t, next)
+			}
+
+			return c.branch(b, t, next)
+		})
+		switch {
+		case t != dflt:
+			c.w("case ")
+			for i, v := range values[t] {
+				if i != 0 {
+					c.w(", ")
+				}
+				c.value(x.Pos(), x.TypeID, v)
+			}
+			c.w(":\n")
+		case len(body) != 0:
+			c.w("default:\n")
+			hasDefault = true
+		default:
+			continue
+		}
+
+		c.out.Write(body)
+		if next != follow && !nf {
+			c.w("fallthrough\n")
+			nf, tm = true, true
+		}
+		noFall = noFall && nf
+		term = term && tm
+	}
+	c.w("}\n")
+	c.stack = c.stack[:len(c.stack)-1]
+	if !hasDefault || ct.breaks != 0 {
+		return false, false
+	}
+
+	return noFall, term
+}
@@ -9,6 +9,7 @@ package irgo
 import (
 	"fmt"
 	"go/token"
+	"math"
 	"reflect"
 	"sort"
 
@@ -18,24 +19,27 @@ import (
 )
 
 var (
-	idComplex64 = ir.TypeID(dict.SID("complex64"))
-	idFloat32   = ir.TypeID(dict.SID("float32"))
-	idFloat64   = ir.TypeID(dict.SID("float64"))
-	idInt16     = ir.TypeID(dict.SID("int16"))
-	idInt32     = ir.TypeID(dict.SID("int32"))
-	idInt32Ptr  = ir.TypeID(dict.SID("*int32"))
-	idInt64     = ir.TypeID(dict.SID("int64"))
-	idInt8      = ir.TypeID(dict.SID("int8"))
-	idInt8Ptr   = ir.TypeID(dict.SID("*int8"))
-	idMain      = ir.NameID(dict.SID("main"))
-	idPVoidPtr  = ir.TypeID(dict.SID("**struct{}"))
-	idUint16    = ir.TypeID(dict.SID("uint16"))
-	idUint32    = ir.TypeID(dict.SID("uint32"))
-	idUint64    = ir.TypeID(dict.SID("uint64"))
-	idUint8     = ir.TypeID(dict.SID("uint8"))
-	idUint8Ptr  = ir.TypeID(dict.SID("*uint8"))
-	idVaList    = ir.TypeID(dict.SID("*struct{_ struct{}}"))
-	idVoidPtr   = ir.TypeID(dict.SID("*struct{}"))
+	idComplex128 = ir.TypeID(dict.SID("complex128"))
+	idComplex256 = ir.TypeID(dict.SID("complex256"))
+	idComplex64  = ir.TypeID(dict.SID("complex64"))
+	idFloat128   = ir.TypeID(dict.SID("float128"))
+	idFloat32    = ir.TypeID(dict.SID("float32"))
+	idFloat64    = ir.TypeID(dict.SID("float64"))
+	idInt16      = ir.TypeID(dict.SID("int16"))
+	idInt32      = ir.TypeID(dict.SID("int32"))
+	idInt32Ptr   = ir.TypeID(dict.SID("*int32"))
+	idInt64      = ir.TypeID(dict.SID("int64"))
+	idInt8       = ir.TypeID(dict.SID("int8"))
+	idInt8Ptr    = ir.TypeID(dict.SID("*int8"))
+	idMain       = ir.NameID(dict.SID("main"))
+	idPVoidPtr   = ir.TypeID(dict.SID("**struct{}"))
+	idUint16     = ir.TypeID(dict.SID("uint16"))
+	idUint32     = ir.TypeID(dict.SID("uint32"))
+	idUint64     = ir.TypeID(dict.SID("uint64"))
+	idUint8      = ir.TypeID(dict.SID("uint8"))
+	idUint8Ptr   = ir.TypeID(dict.SID("*uint8"))
+	idVaList     = ir.TypeID(dict.SID("*struct{_ struct{}}"))
+	idVoidPtr    = ir.TypeID(dict.SID("*struct{}"))
 
 	hooks = strutil.PrettyPrintHooks{
 		reflect.TypeOf(ir.NameID(0)): func(f strutil.Formatter, v interface{}, prefix string, suffix string) {
@@ -87,6 +91,44 @@ type expr struct {
 	token.Position
 }
 
+// results stands for the assignment of the results of a call of a function
+// returning multiple values to temporary variables.
+type results struct {
+	dropped int
+	temps   []int // Variable indices, -1 for results never used.
+	token.Position
+}
+
+// Pos implements operation.
+func (r *results) Pos() token.Position { return r.Position }
+
+// callResult stands for a result of a call of a function returning multiple
+// values, read from its temporary variable.
+type callResult struct {
+	*results
+	index int
+}
+
+// spill stands for storing the values at the top of the stack to the
+// temporary variables, in order, and removing them from the stack.
+type spill struct {
+	temps []int // Variable indices.
+	token.Position
+}
+
+// Pos implements operation.
+func (s *spill) Pos() token.Position { return s.Position }
+
+// spilled stands for the assignment of a spilled value to a temporary
+// variable.
+type spilled struct {
+	index int // Variable index.
+	token.Position
+}
+
+// Pos implements operation.
+func (s *spilled) Pos() token.Position { return s.Position }
+
 func newExpr(n *exprNode, pos token.Position) *expr { return &expr{n, pos} }
 
 // Pos implements operation.
@@ -133,6 +175,26 @@ func (p *exprList) push(e *exprNode) {
 	*p = append(*p, e)
 }
 
+// call pushes a call of a function of type ft. A function returning multiple
+// values is called by a results statement and its results are pushed as
+// callResults. The statement stays in the list below them, it is not a value.
+func (p *exprList) call(op operation, t ir.TypeID, ft *ir.FunctionType, args exprList) {
+	if len(ft.Results) < 2 {
+		p.op(op, t, args)
+		return
+	}
+
+	p.op(op, ft.Results[0].ID(), args)
+	c := p.pop()
+	r := &results{temps: make([]int, len(ft.Results)), Position: op.Pos()}
+	*p = append(*p, &exprNode{Op: r, Childs: exprList{c}, Comma: c.Comma, TypeID: c.TypeID})
+	c.Comma = nil
+	for i, v := range ft.Results {
+		r.temps[i] = -1
+		*p = append(*p, &exprNode{Op: &callResult{r, i}, TypeID: v.ID()})
+	}
+}
+
 func (p *exprList) binop(op operation, t ir.TypeID) {
 	b := p.pop()
 	a := p.pop()
@@ -141,13 +203,39 @@ func (p *exprList) binop(op operation, t ir.TypeID) {
 
 func (p *exprList) pop() *exprNode {
 	s := *p
-	r := s[len(s)-1]
-	*p = s[:len(s)-1]
+	i := s.top()
+	r := s[i]
+	*p = append(s[:i], s[i+1:]...)
 	return r
 }
 
+// pops removes the n values at the top of the stack and returns them in the
+// order they were pushed.
+func (p *exprList) pops(n int) exprList {
+	r := make(exprList, n)
+	for i := n - 1; i >= 0; i-- {
+		r[i] = p.pop()
+	}
+	return r
+}
+
+// top returns the index of the value at the top of the stack, skipping the
+// results and spilled statements.
+func (p exprList) top() int {
+	i := len(p) - 1
+	for {
+		switch p[i].Op.(type) {
+		case *results, *spilled:
+			i--
+		default:
+			return i
+		}
+	}
+}
+
 type node struct {
 	Fallthrough *node
+	In          stack // Non empty when control enters the node with values on the stack.
 	Ops         []operation
 	Out         []*node
 	Stacks      []stack
@@ -193,6 +281,13 @@ func newGraph(gen *gen, ops []ir.Operation) (nodes []*node) {
 
 	g.addEdges(nodes)
 	g.computeStackStates(map[*node]struct{}{}, nodes[0], stack{})
+	if g.spillStacks(nodes) {
+		for _, v := range nodes {
+			v.In = nil
+			v.Stacks = nil
+		}
+		g.computeStackStates(map[*node]struct{}{}, nodes[0], stack{})
+	}
 	g.processExpressions(map[*node]struct{}{}, nodes[0])
 	w := 0
 	for _, v := range nodes {
@@ -213,7 +308,6 @@ func splitPoints(ops []ir.Operation) sort.IntSlice {
 				a = append(a, i+1)
 			}
 		case
-			//TODO 	*ir.JmpP,
 			*ir.Return,
 			*ir.Switch:
 			a = append(a, i+1)
@@ -226,6 +320,125 @@ func splitPoints(ops []ir.Operation) sort.IntSlice {
 	return a[:sortutil.Dedupe(a)]
 }
 
+// takenLabels returns the sorted names of the labels of the function having
+// object index fi whose address is taken.
+func (g *gen) takenLabels(fi int) []int {
+	if a, ok := g.taken[fi]; ok {
+		return a
+	}
+
+	f, ok := g.obj[fi].(*ir.FunctionDefinition)
+	if !ok {
+		return nil
+	}
+
+	defined := map[ir.NameID]bool{}
+	for _, v := range f.Body {
+		if x, ok := v.(*ir.Label); ok && x.NameID != 0 {
+			defined[x.NameID] = true
+		}
+	}
+	taken := map[ir.NameID]bool{}
+	var value func(ir.Value)
+	value = func(v ir.Value) {
+		switch x := v.(type) {
+		case *ir.AddressValue:
+			if x.Label != 0 && x.Index == fi && defined[x.Label] {
+				taken[x.Label] = true
+			}
+		case *ir.CompositeValue:
+			for _, v := range x.Values {
+				value(v)
+			}
+		case *ir.DesignatedValue:
+			value(x.Value)
+		}
+	}
+	for _, v := range f.Body {
+		switch x := v.(type) {
+		case *ir.Const:
+			value(x.Value)
+		case *ir.VariableDeclaration:
+			value(x.Value)
+		}
+	}
+	for _, v := range g.obj {
+		if x, ok := v.(*ir.DataDefinition); ok {
+			value(x.Value)
+		}
+	}
+	var a []int
+	for k := range taken {
+		a = append(a, int(k))
+	}
+	sort.Ints(a)
+	g.taken[fi] = a
+	return a
+}
+
+// labelToken returns the Go expression of the address of the label nm of the
+// function having object index fi. Label addresses point into a byte array,
+// the label table of the function, so they are valid Go pointers and the
+// index of a label is its address minus the address of the table.
+func (g *gen) labelToken(pos token.Position, v ir.Value, fi int, nm ir.NameID) string {
+	for i, w := range g.takenLabels(fi) {
+		if ir.NameID(w) == nm {
+			g.labelTabs[fi] = len(g.takenLabels(fi))
+			return fmt.Sprintf("&labels%d[%d]", fi, i)
+		}
+	}
+	g.unsupported(pos, v, "address of an undefined label")
+	panic("internal error")
+}
+
+// computedGotos returns body with the JmpP operations replaced by a switch
+// over the indices of the labels of the function having index fi whose
+// address is taken.
+func (g *gen) computedGotos(fi int, body []ir.Operation) []ir.Operation {
+	var jmps []int
+	for i, v := range body {
+		if _, ok := v.(*ir.JmpP); ok {
+			jmps = append(jmps, i)
+		}
+	}
+	if len(jmps) == 0 {
+		return body
+	}
+
+	a := g.takenLabels(fi)
+	f := g.obj[fi].Base()
+	r := make([]ir.Operation, 0, len(body)+5*len(jmps))
+	for i, v := range body {
+		if len(jmps) == 0 || jmps[0] != i {
+			r = append(r, v)
+			continue
+		}
+
+		jmps = jmps[1:]
+		pos := v.Pos()
+		if len(a) == 0 {
+			g.unsupported(pos, v, "computed goto in a function not taking label addresses")
+		}
+
+		// The last label is the default target, jumping to an address
+		// not taken in the function is undefined behavior.
+		sw := &ir.Switch{Default: ir.Label{NameID: ir.NameID(a[len(a)-1])}, TypeID: idInt64, Position: pos}
+		for i, v := range a[:len(a)-1] {
+			sw.Values = append(sw.Values, &ir.Int64Value{Value: int64(i)})
+			sw.Labels = append(sw.Labels, ir.Label{NameID: ir.NameID(v)})
+		}
+		table := &ir.AddressValue{Index: fi, Label: ir.NameID(a[0]), Linkage: f.Linkage, NameID: f.NameID}
+		r = append(r,
+			&ir.Convert{TypeID: idVoidPtr, Result: idInt64, Position: pos},
+			&ir.Const{TypeID: idVoidPtr, Value: table, Position: pos},
+			&ir.Convert{TypeID: idVoidPtr, Result: idInt64, Position: pos},
+			&ir.Sub{TypeID: idInt64, Position: pos},
+			sw,
+		)
+	}
+	return r
+}
+
 func (g *graph) addEdges(nodes []*node) {
 	// Collect symbol table.
 	a := make([]*ir.Label, len(nodes))
@@ -416,9 +629,7 @@ func (g *graph) computeStackStates(m map[*node]struct{}, n *node, s stack) {
 
 	m[n] = struct{}{}
 	n.live = true
-	if len(s) != 0 {
-		TODO("%s: TODO stack(%v): %v\n\t%s", n.Ops[0].Pos(), len(s), n.Ops[0], s)
-	}
+	n.In = s
 	for i := 0; i < len(n.Ops); i++ {
 		op := n.Ops[i]
 		//fmt.Printf("%#05x(%v) %v %v (pre)\n", i, len(n.Ops), op, s) //TODO-
@@ -458,6 +669,8 @@ func (g *graph) computeStackStates(m map[*node]struct{}, n *node, s stack) {
 			}
 		case *ir.Const64:
 			s = s.push(stackItem{TypeID: x.TypeID, Value: &ir.Int64Value{Value: x.Value}})
+		case *ir.ConstC128:
+			s = s.push(stackItem{TypeID: x.TypeID, Value: &ir.Complex128Value{Value: x.Value}})
 		case *ir.Convert:
 			s = s.pop().pushT(x.Result)
 		case *ir.Copy:
@@ -516,10 +729,12 @@ func (g *graph) computeStackStates(m map[*node]struct{}, n *node, s stack) {
 		case *ir.Jnz:
 			if !x.LOp {
 				s = s.pop()
+				g.computeStackStates(m, g.label2codeNode[label2(x.NameID, x.Number)], s)
 			}
 		case *ir.Jz:
 			if !x.LOp {
 				s = s.pop()
+				g.computeStackStates(m, g.label2codeNode[label2(x.NameID, x.Number)], s)
 			}
 		case
 			*ir.Eq,
@@ -565,6 +780,8 @@ func (g *graph) computeStackStates(m map[*node]struct{}, n *node, s stack) {
 			s = s.pop().pop().pushT(x.TypeID)
 		case *ir.Result:
 			s = s.pushT(g.qptrID(g.gen.f.t.Results[x.Index].ID(), x.Address))
+		case *spill:
+			s = s[: len(s)-len(x.temps) : len(s)-len(x.temps)]
 		case *ir.Store:
 			v := s.tos()
 			s = s.pop().pop().push(v)
@@ -597,7 +814,7 @@ func (g *graph) computeStackStates(m map[*node]struct{}, n *node, s stack) {
 			*ir.VariableDeclaration:
 			// nop
 		default:
-			TODO("%s: %T", x.Pos(), x)
+			g.unsupported(x.Pos(), x, "operation")
 		}
 		n.Stacks = append(n.Stacks, s)
 		// fmt.Printf("%#05x(%v) %v %v\n", i, len(n.Ops), n.Ops[i], s) //TODO-
@@ -609,6 +826,219 @@ func (g *graph) computeStackStates(m map[*node]struct{}, n *node, s stack) {
 	}
 }
 
+// branch is a point in a node where control may leave it.
+type branch struct {
+	i     int // Index of the branch operation, len(n.Ops) for falling through.
+	succs []*node
+}
+
+// branches returns the points where control may leave n.
+func (g *graph) branches(n *node) (r []branch) {
+	for i, op := range n.Ops {
+		switch x := op.(type) {
+		case *ir.Jmp:
+			if !x.Cond {
+				r = append(r, branch{i, []*node{g.label2codeNode[label2(x.NameID, x.Number)]}})
+			}
+		case *ir.Jnz:
+			if !x.LOp {
+				r = append(r, branch{i, []*node{g.label2codeNode[label2(x.NameID, x.Number)]}})
+			}
+		case *ir.Jz:
+			if !x.LOp {
+				r = append(r, branch{i, []*node{g.label2codeNode[label2(x.NameID, x.Number)]}})
+			}
+		case *ir.Switch:
+			b := branch{i: i}
+			for _, v := range x.Labels {
+				b.succs = append(b.succs, g.label2codeNode[label2(v.NameID, v.Number)])
+			}
+			b.succs = append(b.succs, g.label2codeNode[label2(x.Default.NameID, x.Default.Number)])
+			r = append(r, b)
+		}
+	}
+	if n.Fallthrough != nil {
+		switch {
+		case len(r) != 0 && r[len(r)-1].i == len(n.Ops)-1:
+			r[len(r)-1].succs = append(r[len(r)-1].succs, n.Fallthrough)
+		default:
+			r = append(r, branch{len(n.Ops), []*node{n.Fallthrough}})
+		}
+	}
+	return r
+}
+
+// spillStacks rewrites the nodes entered with values on the stack. The values
+// are stored to temporary variables before the branches to such nodes and
+// loaded back at their start. Nodes entered by the same branch share the
+// temporary variables. spillStacks reports whether any node was rewritten,
+// the stack states must be then computed again.
+func (g *graph) spillStacks(nodes []*node) bool {
+	// Find the nodes sharing temporary variables.
+	class := map[*node]*node{}
+	var find func(*node) *node
+	find = func(n *node) *node {
+		if c := class[n]; c != n {
+			c = find(c)
+			class[n] = c
+			return c
+		}
+
+		return n
+	}
+	for _, n := range nodes {
+		if n.live && n.valid && len(n.In) != 0 {
+			class[n] = n
+		}
+	}
+	if len(class) == 0 {
+		return false
+	}
+
+	branches := map[*node][]branch{}
+	for _, n := range nodes {
+		if !n.live || !n.valid {
+			continue
+		}
+
+		branches[n] = g.branches(n)
+		for _, b := range branches[n] {
+			var first *node
+			for _, v := range b.succs {
+				if v == nil || len(v.In) == 0 {
+					continue
+				}
+
+				switch {
+				case first == nil:
+					first = v
+				default:
+					class[find(v)] = find(first)
+				}
+			}
+		}
+	}
+
+	// Allocate the temporary variables.
+	temps := map[*node][]int{}
+	for _, n := range nodes {
+		if len(n.In) == 0 || !n.live || !n.valid {
+			continue
+		}
+
+		c := find(n)
+		if _, ok := temps[c]; !ok {
+			for _, v := range c.In {
+				i := len(g.gen.f.varNfo)
+				g.gen.f.varNfo = append(g.gen.f.varNfo, varNfo{def: &ir.VariableDeclaration{Index: i, TypeID: v.TypeID, Position: c.Ops[0].Pos()}})
+				temps[c] = append(temps[c], i)
+			}
+		}
+		if len(c.In) != len(n.In) {
+			panic("internal error")
+		}
+	}
+
+	load := func(t []int, s stack, pos token.Position) (r []operation) {
+		for i, v := range t {
+			r = append(r, &ir.Variable{Index: v, TypeID: s[i].TypeID, Position: pos})
+		}
+		return r
+	}
+
+	for _, n := range nodes {
+		if !n.live || !n.valid {
+			continue
+		}
+
+		type insert struct {
+			i   int
+			ops []operation
+		}
+		var a []insert
+		if len(n.In) != 0 {
+			i := 0
+			if _, ok := n.Ops[0].(*ir.Label); ok {
+				i++
+			}
+			a = append(a, insert{i, load(temps[find(n)], n.In, n.Ops[0].Pos())})
+		}
+		depth := func(i int) int {
+			if i == 0 {
+				return len(n.In)
+			}
+
+			return len(n.Stacks[i-1])
+		}
+		for _, b := range branches[n] {
+			var t []int
+			for _, v := range b.succs {
+				if v != nil && len(v.In) != 0 {
+					t = temps[find(v)]
+					break
+				}
+			}
+			if t == nil {
+				continue
+			}
+
+			// The stored values are those remaining below the
+			// operands of the branch.
+			i := b.i
+			for i > 0 && depth(i) > len(t) {
+				i--
+			}
+			if depth(i) != len(t) {
+				panic("internal error")
+			}
+
+			pos := n.Ops[len(n.Ops)-1].Pos()
+			if b.i < len(n.Ops) {
+				pos = n.Ops[b.i].Pos()
+			}
+			a = append(a, insert{i, []operation{&spill{t, pos}}})
+			if b.i < len(n.Ops)-1 {
+				// Control may stay in the node, load the values
+				// back.
+				a = append(a, insert{b.i + 1, load(t, n.Stacks[b.i], pos)})
+			}
+		}
+		if len(a) == 0 {
+			continue
+		}
+
+		sort.SliceStable(a, func(i, j int) bool { return a[i].i < a[j].i })
+		var ops []operation
+		i := 0
+		for _, v := range a {
+			ops = append(append(ops, n.Ops[i:v.i]...), v.ops...)
+			i = v.i
+		}
+		n.Ops = append(ops, n.Ops[i:]...)
+	}
+	return true
+}
+
+// isValue reports whether n leaves a value on the stack.
+func (g *graph) isValue(n *exprNode) bool {
+	switch x := n.Op.(type) {
+	case
+		*ir.Drop,
+		*ir.Jnz,
+		*ir.Jz,
+		*ir.Switch,
+		*results,
+		*spilled:
+
+		return false
+	case *ir.Call:
+		return len(g.tc.MustType(x.TypeID).(*ir.FunctionType).Results) != 0
+	case *ir.CallFP:
+		return len(g.tc.MustType(n.Childs[0].TypeID).(*ir.PointerType).Element.(*ir.FunctionType).Results) != 0
+	}
+	return true
+}
+
 func (g *graph) processExpressionList(ops []operation, stacks []stack) (l exprList, _ int) {
 	for i, op := range ops {
 		var t ir.TypeID
@@ -617,15 +1047,19 @@ func (g *graph) processExpressionList(ops []operation, stacks []stack) (l exprLi
 		}
 		switch x := op.(type) {
 		case *ir.Call:
-			s := len(l) - x.Arguments
-			args := l[s:]
-			l = l[:s:s]
-			l.op(x, t, args)
+			args := l.pops(x.Arguments)
+			ft := g.tc.MustType(x.TypeID).(*ir.FunctionType)
+			if len(ft.Results) > 1 {
+				g.spillLeft(&l, x.Pos())
+			}
+			l.call(x, t, ft, args)
 		case *ir.CallFP:
-			s := len(l) - x.Arguments - 1
-			args := l[s:]
-			l = l[:s:s]
-			l.op(x, t, args)
+			args := l.pops(x.Arguments + 1)
+			ft := g.tc.MustType(args[0].TypeID).(*ir.PointerType).Element.(*ir.FunctionType)
+			if len(ft.Results) > 1 {
+				g.spillLeft(&l, x.Pos())
+			}
+			l.call(x, t, ft, args)
 		case *ir.Dup:
 			l.unop(x, t)
 			tos := l.pop()
@@ -640,9 +1074,23 @@ func (g *graph) processExpressionList(ops []operation, stacks []stack) (l exprLi
 				l.unop(x, t)
 			}
 		case *ir.Drop:
-			if !x.LOp {
-				l.unop(x, t)
+			if x.LOp {
+				break
 			}
+
+			if r, ok := l[l.top()].Op.(*callResult); ok {
+				l.pop()
+				if r.dropped++; r.dropped == len(r.temps) && l[len(l)-1].Op == r.results {
+					// No result is used, drop the call instead.
+					s := l[len(l)-1]
+					s.Childs[0].Comma = s.Comma
+					l[len(l)-1] = s.Childs[0]
+					l.unop(x, t)
+				}
+				break
+			}
+
+			l.unop(x, t)
 		case
 			*ir.Bool,
 			*ir.Convert,
@@ -656,11 +1104,22 @@ func (g *graph) processExpressionList(ops []operation, stacks []stack) (l exprLi
 			*ir.Switch:
 
 			l.unop(x, t)
+		case *spill:
+			// Each value is assigned where it was computed.
+			j := len(x.temps)
+			for k := len(l) - 1; j != 0; k-- {
+				if g.isValue(l[k]) {
+					j--
+					l[k] = &exprNode{Op: &spilled{x.temps[j], x.Pos()}, Childs: exprList{l[k]}}
+				}
+			}
 		case *ir.Label:
 			switch {
 			case x.LAnd || x.LOr:
+				g.conditional(l, 2)
 				l.binop(x, t)
 			case x.Cond:
+				g.conditional(l, 3)
 				c := l.pop()
 				b := l.pop()
 				a := l.pop()
@@ -697,6 +1156,7 @@ func (g *graph) processExpressionList(ops []operation, stacks []stack) (l exprLi
 			*ir.Argument,
 			*ir.Const,
 			*ir.Const64,
+			*ir.ConstC128,
 			*ir.Global,
 			*ir.Nil,
 			*ir.Result,
@@ -719,12 +1179,97 @@ func (g *graph) processExpressionList(ops []operation, stacks []stack) (l exprLi
 			*ir.BeginScope:
 			// nop
 		default:
-			TODO("%s: %T", x.Pos(), x)
+			g.unsupported(x.Pos(), x, "operation in an expression")
 		}
 	}
 	return l, len(ops)
 }
 
+// conditional reports the statements hoisted out of the conditionally
+// evaluated operands of the operator having n operands at the top of l. Such
+// statements, the call of a function returning multiple values and the spills
+// preceding it, would be executed unconditionally before the expression.
+func (g *graph) conditional(l exprList, n int) {
+	i := len(l)
+	for ; n != 0; n-- {
+		i = l[:i].top()
+	}
+	for _, v := range l[i+1:] {
+		switch x := v.Op.(type) {
+		case *results, *spilled:
+			g.unsupported(x.Pos(), x, "call of a function returning multiple values in a conditionally evaluated operand")
+		}
+	}
+}
+
+// spillLeft assigns the values in l, the operands left of a call of a function
+// returning multiple values, to temporary variables where they are computed.
+// The call is made by a statement preceding the expression, so computing the
+// values later would reorder their side effects after the call.
+func (g *graph) spillLeft(p *exprList, pos token.Position) {
+	l := *p
+	reads := map[*exprNode]*exprNode{}
+	for k := 0; k < len(l); k++ {
+		n := l[k]
+		if !g.isValue(n) || g.stable(n) {
+			continue
+		}
+
+		if r := reads[n]; r != nil { // Dup.
+			l[k] = r
+			continue
+		}
+
+		i := len(g.gen.f.varNfo)
+		g.gen.f.varNfo = append(g.gen.f.varNfo, varNfo{def: &ir.VariableDeclaration{Index: i, TypeID: n.TypeID, Position: pos}})
+		r := &exprNode{Op: &ir.Variable{Index: i, TypeID: n.TypeID, Position: pos}, TypeID: n.TypeID}
+		reads[n] = r
+		l[k] = &exprNode{Op: &spilled{i, pos}, Childs: exprList{n}}
+		l = append(l[:k+1], append(exprList{r}, l[k+1:]...)...)
+		k++
+	}
+	*p = l
+}
+
+// stable reports whether the value of n does not depend on when it is
+// computed.
+func (g *graph) stable(n *exprNode) bool {
+	switch x := n.Op.(type) {
+	case
+		*ir.Const,
+		*ir.Const32,
+		*ir.Const64,
+		*ir.ConstC128,
+		*ir.Nil,
+		*ir.StringConst:
+
+		return true
+	case *ir.Argument:
+		return x.Address
+	case *ir.Global:
+		return x.Address
+	case *ir.Result:
+		return x.Address
+	case *ir.Variable:
+		return x.Address || g.gen.f.varNfo[x.Index].def.NameID == 0
+	}
+	return false
+}
+
+// resultTemps allocates the temporary variables of the callResults in n.
+func (g *graph) resultTemps(n *exprNode) {
+	for ; n != nil; n = n.Comma {
+		if x, ok := n.Op.(*callResult); ok && x.temps[x.index] < 0 {
+			i := len(g.gen.f.varNfo)
+			g.gen.f.varNfo = append(g.gen.f.varNfo, varNfo{def: &ir.VariableDeclaration{Index: i, TypeID: n.TypeID, Position: x.Pos()}})
+			x.temps[x.index] = i
+		}
+		for _, v := range n.Childs {
+			g.resultTemps(v)
+		}
+	}
+}
+
 func (g *graph) processExpressions(m map[*node]struct{}, n *node) {
 	if n == nil || !n.valid {
 		return
@@ -743,6 +1288,7 @@ func (g *graph) processExpressions(m map[*node]struct{}, n *node) {
 				// Start of an expression or expression list.
 				l, nodes := g.processExpressionList(n.Ops[i:], n.Stacks[i:])
 				for _, v := range l {
+					g.resultTemps(v)
 					out = append(out, newExpr(v, x.Pos()))
 				}
 				i += nodes
@@ -755,6 +1301,7 @@ func (g *graph) processExpressions(m map[*node]struct{}, n *node) {
 			*ir.Call,
 			*ir.CallFP,
 			*ir.Const64,
+			*ir.ConstC128,
 			*ir.Global,
 			*ir.Nil,
 			*ir.Result,
@@ -763,6 +1310,7 @@ func (g *graph) processExpressions(m map[*node]struct{}, n *node) {
 			// Start of an expression or expression list.
 			l, nodes := g.processExpressionList(n.Ops[i:], n.Stacks[i:])
 			for _, v := range l {
+				g.resultTemps(v)
 				out = append(out, newExpr(v, x.Pos()))
 			}
 			i += nodes
@@ -787,7 +1335,7 @@ func (g *graph) processExpressions(m map[*node]struct{}, n *node) {
 			out = append(out, x)
 			i++
 		default:
-			TODO("%s: %T %#05x", x.Pos(), x, i)
+			g.unsupported(x.Pos(), x, "operation")
 		}
 	}
 	n.Ops = out
@@ -831,6 +1379,23 @@ type switchPair struct {
 
 type switchPairs []switchPair
 
+// switchPairs returns the case values of x paired with their labels, sorted by
+// value.
+func (g *gen) switchPairs(x *ir.Switch) switchPairs {
+	var a switchPairs
+	for i, v := range x.Values {
+		switch v.(type) {
+		case *ir.Int32Value, *ir.Int64Value:
+			// ok
+		default:
+			g.unsupported(x.Pos(), v, "switch case value")
+		}
+		a = append(a, switchPair{v, &x.Labels[i]})
+	}
+	sort.Sort(a)
+	return a
+}
+
 func (s switchPairs) Len() int { return len(s) }
 
 func (s switchPairs) Less(i, j int) bool {
@@ -839,8 +1404,6 @@ func (s switchPairs) Less(i, j int) bool {
 		return x.Value < s[j].Value.(*ir.Int32Value).Value
 	case *ir.Int64Value:
 		return x.Value < s[j].Value.(*ir.Int64Value).Value
-	default:
-		TODO("%T", x)
 	}
 	panic("internal error")
 }
@@ -871,6 +1434,8 @@ func isZeroValue(v ir.Value) bool {
 			}
 		}
 		return true
+	case *ir.DesignatedValue:
+		return isZeroValue(x.Value)
 	case *ir.Int32Value:
 		return x.Value == 0
 	case *ir.Int64Value:
@@ -887,12 +1452,51 @@ func isZeroValue(v ir.Value) bool {
 		return false
 	case *ir.WideStringValue:
 		return false
-	default:
-		TODO("isZeroValue %T", x)
 	}
 	panic("internal error")
 }
 
+// designated calls f for every item of x with the index of the array element
+// or struct field it initializes, following the rules of C designators.
+func designated(x *ir.CompositeValue, f func(i int, v ir.Value)) {
+	i := 0
+	for _, v := range x.Values {
+		if d, ok := v.(*ir.DesignatedValue); ok {
+			i, v = d.Index, d.Value
+		}
+		f(i, v)
+		i++
+	}
+}
+
+// complex64Bits returns the complex64 value having the memory representation v.
+func complex64Bits(v int64) complex128 {
+	return complex(float64(math.Float32frombits(uint32(v))), float64(math.Float32frombits(uint32(v>>32))))
+}
+
+// floatLit returns the Go expression of the floating point constant v.
+func floatLit(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	case math.IsNaN(v):
+		return "math.NaN()"
+	case v == 0 && math.Signbit(v):
+		return "nzf64"
+	}
+	return fmt.Sprint(v)
+}
+
+func isSigned(t ir.Type) bool {
+	switch t.Kind() {
+	case ir.Int8, ir.Int16, ir.Int32, ir.Int64:
+		return true
+	}
+	return false
+}
+
 func isIntegralType(t ir.TypeID) bool {
 	switch t {
 	case
@@ -939,10 +1543,11 @@ func isOne(n *exprNode) bool {
 	switch x := n.Op.(type) {
 	case *ir.Const32:
 		return x.Value == 1
+	case *ir.Const64:
+		return x.Value == 1
 	case *ir.Convert:
 		return isOne(n.Childs[0])
 	default:
-		TODO("%s: %T", x.Pos(), x)
 		return false
 	}
 }
@@ -8,14 +8,13 @@ package irgo
 import (
 	"bytes"
 	"fmt"
+	"go/parser"
 	"go/token"
 	"io"
 	"math"
-	"os"
 	"path"
 	"path/filepath"
 	"regexp"
-	"runtime"
 	"sort"
 	"strconv"
 	"strings"
@@ -23,14 +22,14 @@ import (
 
 	"github.com/cznic/internal/buffer"
 	"github.com/cznic/ir"
-	"github.com/cznic/mathutil"
 	"github.com/cznic/xc"
 )
 
 const (
-	crt          = "crt"
-	mallocAllign = 2 * ptrSize
-	ptrSize      = mathutil.UintPtrBits / 8
+	crtImportPath = "github.com/cznic/crt"
+	crtName       = "crt"
+
+	defaultMaxFileSize = 1 << 18
 )
 
 var (
@@ -38,18 +37,12 @@ var (
 	Testing bool
 	// Testing hook.
 	FTrace bool
+	// Testing hook, write all function bodies using labels and gotos.
+	unstructured bool
 
 	dict = xc.Dict
 )
 
-//TODO remove me.
-func TODO(msg string, more ...interface{}) string { //TODOOK
-	_, fn, fl, _ := runtime.Caller(1)
-	fmt.Fprintf(os.Stderr, "%s:%d: %v\n", path.Base(fn), fl, fmt.Sprintf(msg, more...))
-	os.Stderr.Sync()
-	panic(fmt.Errorf("%s:%d: TODO %v", path.Base(fn), fl, fmt.Sprintf(msg, more...)))
-}
-
 type varNfo struct {
 	def   *ir.VariableDeclaration
 	i     int
@@ -67,6 +60,7 @@ type cname struct {
 	ir.NameID
 	exported bool
 	index    int
+	pkg      ir.NameID
 }
 
 type fn struct {
@@ -87,51 +81,79 @@ func newFn(tc ir.TypeCache, f *ir.FunctionDefinition) *fn {
 type gen struct {
 	builtins  map[int]struct{} // Object#
 	copies    map[ir.TypeID]struct{}
+	crt       string        // Local name of the C runtime package.
+	defs      []int         // Offsets in out where object definitions end.
+	diags     []*Diagnostic // Unsupported expressions of the current object.
 	elems     map[ir.TypeID]struct{}
+	errs      ErrorList
 	f         *fn
 	fns       map[ir.NameID]*ir.FunctionDefinition
+	imports   map[ir.NameID]string // Package: import path.
+	labelTabs map[int]int          // Function object index: number of labels whose address is taken.
 	labels    map[int]int
-	lblUsed   map[int]int
+	layouts   map[ir.TypeID]*goLayout // Struct type: cached Go layout.
+	lossy     bool                    // The current object lowers long double to float64.
 	mangled   map[cname]ir.NameID
 	model     ir.MemoryModel
 	obj       []ir.Object
 	out       *buffer.Bytes
+	portable  bool
+	postbits  map[ir.TypeID]struct{}
 	postIncs  map[ir.TypeID]struct{}
+	prebits   map[ir.TypeID]struct{}
 	preIncs   map[ir.TypeID]struct{}
+	sections  [3]int // Offsets in out of the helpers, types and string table.
 	stable    map[ir.TypeID]int
 	storebits map[ir.TypeID]struct{}
 	stores    map[ir.TypeID]struct{}
 	strTab    map[ir.StringID]int
 	strings   buffer.Bytes
+	stubs     bool
+	taken     map[int][]int // Function object index: takenLabels.
 	tc        ir.TypeCache
 	tm        map[ir.TypeID]string
 	types     map[ir.TypeID]struct{}
+	warnings  func(*Diagnostic)
 }
 
 func newGen(obj []ir.Object, tm map[ir.TypeID]string, o *options) *gen {
-	model, err := ir.NewMemoryModel()
-	if err != nil {
-		panic(err)
+	model := o.model
+	if model == nil {
+		var err error
+		if model, err = ir.NewMemoryModel(); err != nil {
+			panic(err)
+		}
 	}
+	model = lowerLongDouble(model)
 
 	g := &gen{
 		builtins:  map[int]struct{}{},
 		copies:    map[ir.TypeID]struct{}{},
+		crt:       o.crtName,
 		elems:     map[ir.TypeID]struct{}{},
 		fns:       map[ir.NameID]*ir.FunctionDefinition{},
+		imports:   o.imports,
+		labelTabs: map[int]int{},
+		layouts:   map[ir.TypeID]*goLayout{},
 		mangled:   map[cname]ir.NameID{},
 		model:     model,
 		obj:       obj,
 		out:       &buffer.Bytes{},
+		portable:  o.portable,
+		postbits:  map[ir.TypeID]struct{}{},
 		postIncs:  map[ir.TypeID]struct{}{},
+		prebits:   map[ir.TypeID]struct{}{},
 		preIncs:   map[ir.TypeID]struct{}{},
 		stable:    map[ir.TypeID]int{},
 		storebits: map[ir.TypeID]struct{}{},
 		stores:    map[ir.TypeID]struct{}{},
 		strTab:    map[ir.StringID]int{},
+		stubs:     o.continueOnError,
+		taken:     map[int][]int{},
 		tc:        o.tc,
 		tm:        tm,
 		types:     map[ir.TypeID]struct{}{},
+		warnings:  o.warnings,
 	}
 	for _, v := range obj {
 		switch x := v.(type) {
@@ -171,7 +193,7 @@ func (g *gen) mangle(nm ir.NameID, exported bool, index int) ir.NameID {
 }
 
 func (g *gen) mangle2(pkg, nm ir.NameID, exported bool, index int) ir.NameID {
-	k := cname{nm, exported, index}
+	k := cname{nm, exported, index, pkg}
 	if x, ok := g.mangled[k]; ok {
 		return x
 	}
@@ -211,6 +233,59 @@ func (g *gen) mangle2(pkg, nm ir.NameID, exported bool, index int) ir.NameID {
 	return id
 }
 
+// global returns the Go name of object #i. Objects of other packages are
+// referred to by a qualified identifier.
+func (g *gen) global(pos token.Position, op interface{}, i int) ir.NameID {
+	b := g.obj[i].Base()
+	if b.Package != 0 {
+		if b.Linkage != ir.ExternalLinkage {
+			g.unsupported(pos, op, "reference to %s.%s with internal linkage", b.Package, b.NameID)
+		}
+
+		if _, ok := g.imports[b.Package]; !ok {
+			g.unsupported(pos, op, "reference to %s.%s, no import path for package %[1]s", b.Package, b.NameID)
+		}
+	}
+	return g.mangle2(b.Package, b.NameID, b.Linkage == ir.ExternalLinkage, -1)
+}
+
+// unsupported reports an IR construct the generator cannot translate. It does
+// not return.
+func (g *gen) unsupported(pos token.Position, op interface{}, msg string, arg ...interface{}) {
+	panic(&Diagnostic{
+		Position: pos,
+		Op:       fmt.Sprintf("%T", op),
+		Severity: Error,
+		Msg:      "unsupported " + fmt.Sprintf(msg, arg...),
+	})
+}
+
+// unsupportedExpr recovers from an unsupported construct in an expression and
+// records it, so the rest of the function is written and every unsupported
+// expression is reported. The object is then translated no further.
+func (g *gen) unsupportedExpr() {
+	switch x := recover().(type) {
+	case nil:
+		// ok
+	case *Diagnostic:
+		for _, v := range g.diags {
+			if *v == *x {
+				return
+			}
+		}
+
+		g.diags = append(g.diags, x)
+	default:
+		panic(x)
+	}
+}
+
+func (g *gen) warn(d *Diagnostic) {
+	if g.warnings != nil {
+		g.warnings(d)
+	}
+}
+
 func (g *gen) w(msg string, arg ...interface{}) {
 	if _, err := fmt.Fprintf(g.out, msg, arg...); err != nil {
 		panic(err)
@@ -247,10 +322,16 @@ func (g *gen) typ0(buf *buffer.Bytes, t ir.Type, full bool) {
 		buf.WriteString("float32 ")
 	case ir.Float64:
 		buf.WriteString("float64 ")
+	case ir.Float128:
+		g.lossy = true
+		buf.WriteString("float64 ")
 	case ir.Complex64:
 		buf.WriteString("complex64 ")
 	case ir.Complex128:
 		buf.WriteString("complex128 ")
+	case ir.Complex256:
+		g.lossy = true
+		buf.WriteString("complex128 ")
 	case ir.Array:
 		at := t.(*ir.ArrayType)
 		n := at.Items
@@ -258,12 +339,29 @@ func (g *gen) typ0(buf *buffer.Bytes, t ir.Type, full bool) {
 		g.typ0(buf, at.Item, false)
 	case ir.Struct:
 		if full {
+			st := t.(*ir.StructOrUnionType)
+			if g.packed(st) {
+				if g.portable && !isPortable(t) {
+					g.unsupported(token.Position{}, t, "portable layout of packed struct type %v", t)
+				}
+
+				fmt.Fprintf(buf, "[%v]byte", g.model.Sizeof(t))
+				return
+			}
+
+			l := g.layout(st)
 			buf.WriteString("struct{")
-			for i, v := range t.(*ir.StructOrUnionType).Fields {
+			for i, v := range g.goFields(st) {
+				if n := l.pads[i]; n != 0 {
+					fmt.Fprintf(buf, "_ [%v]byte;", n)
+				}
 				fmt.Fprintf(buf, "X%s ", g.fld(t.ID(), i))
 				g.typ0(buf, v, false)
 				buf.WriteByte(';')
 			}
+			if l.tail != 0 {
+				fmt.Fprintf(buf, "_ [%v]byte", l.tail)
+			}
 			buf.WriteString("}")
 			return
 		}
@@ -278,7 +376,7 @@ func (g *gen) typ0(buf *buffer.Bytes, t ir.Type, full bool) {
 				g.typ0(buf, v, false)
 				buf.WriteByte(';')
 			}
-			fmt.Fprintf(buf, "}; U [%v]byte", g.model.Sizeof(t))
+			fmt.Fprintf(buf, "}; U [%v]byte", g.unionSize(t.(*ir.StructOrUnionType)))
 			buf.WriteString("}")
 			return
 		}
@@ -303,7 +401,7 @@ func (g *gen) typ0(buf *buffer.Bytes, t ir.Type, full bool) {
 		g.typ0(buf, e, false)
 	case ir.Function:
 		ft := t.(*ir.FunctionType)
-		fmt.Fprintf(buf, "func(*%s.TLS", crt)
+		fmt.Fprintf(buf, "func(*%s.TLS", g.crt)
 		for _, v := range ft.Arguments {
 			buf.WriteString(", ")
 			g.typ0(buf, v, false)
@@ -318,10 +416,17 @@ func (g *gen) typ0(buf *buffer.Bytes, t ir.Type, full bool) {
 		case 1:
 			g.typ0(buf, ft.Results[0], false)
 		default:
-			TODO("")
+			buf.WriteByte('(')
+			for i, v := range ft.Results {
+				if i != 0 {
+					buf.WriteString(", ")
+				}
+				g.typ0(buf, v, false)
+			}
+			buf.WriteByte(')')
 		}
 	default:
-		TODO("%v", t.Kind())
+		g.unsupported(token.Position{}, t, "type kind %v", t.Kind())
 	}
 }
 
@@ -345,17 +450,279 @@ func (g *gen) typ(t ir.Type) ir.NameID {
 
 func (g *gen) typ2(t ir.TypeID) ir.NameID { return g.typ(g.tc.MustType(t)) }
 
+// isPortable reports whether the size of t is the same on all supported
+// architectures.
+func isPortable(t ir.Type) bool { return lowered32.Sizeof(t) == lowered64.Sizeof(t) }
+
+// sizeof returns the size of t as a Go expression. In portable mode sizes that
+// depend on the target architecture are computed by unsafe.Sizeof.
+func (g *gen) sizeof(t ir.Type) string {
+	if !g.portable || isPortable(t) {
+		return fmt.Sprint(g.model.Sizeof(t))
+	}
+
+	return fmt.Sprintf("unsafe.Sizeof(*(*%v)(nil))", g.typ(t))
+}
+
+// bitField returns the mask, offset and sign extension shift arguments of the
+// bit field helpers for a field of bits bits at offset off stored in type t.
+func (g *gen) bitField(t ir.TypeID, bits, off int) string {
+	w := 8 * int(g.model.Sizeof(g.tc.MustType(t)))
+	return fmt.Sprintf("%v, %v, %v", (uint64(1)<<uint(bits)-1)<<uint(off), off, w-bits)
+}
+
+// bitFieldExt returns the Go operators extending the value of a bit field of
+// bits bits, read from storage type t and converted to the field type bt, to
+// the width of bt. The helpers extend the field according to the signedness of
+// t. A signed field stored in an unsigned type is sign extended by a shift left
+// followed by an arithmetic shift right, an unsigned field stored in a signed
+// type is masked.
+func (g *gen) bitFieldExt(t, bt ir.TypeID, bits int) string {
+	T, BT := g.tc.MustType(t), g.tc.MustType(bt)
+	switch {
+	case isSigned(BT) && !isSigned(T):
+		return fmt.Sprintf("<<%[1]v>>%[1]v", 8*g.model.Sizeof(BT)-int64(bits))
+	case !isSigned(BT) && isSigned(T):
+		return fmt.Sprintf("&%v", uint64(1)<<uint(bits)-1)
+	}
+	return ""
+}
+
+// goAlign returns the alignment of type t in Go on the target architecture.
+// Go aligns scalars to their size, but at most to the size of a pointer.
+func (g *gen) goAlign(t ir.Type) int64 {
+	switch x := t.(type) {
+	case *ir.ArrayType:
+		return g.goAlign(x.Item)
+	case *ir.StructOrUnionType:
+		if x.Kind() == ir.Struct {
+			return g.layout(x).align
+		}
+
+		r := int64(1)
+		for _, v := range x.Fields {
+			if a := g.goAlign(v); a > r {
+				r = a
+			}
+		}
+		return r
+	}
+
+	a := g.model.Sizeof(t)
+	switch t.Kind() {
+	case ir.Complex64, ir.Complex128, ir.Complex256:
+		a /= 2
+	}
+	if p := int64(g.model[ir.Pointer].Size); a > p {
+		a = p
+	}
+	return a
+}
+
+// goLayout describes the Go struct type of a struct type. The padding makes
+// the Go layout match the memory model.
+type goLayout struct {
+	align int64   // Alignment in Go, 1 if packed.
+	pads  []int64 // Padding before every field, nil if packed.
+	tail  int64   // Padding after the last field.
+}
+
+// layout returns the Go layout of struct type t, computing it only once per
+// type. The layout is packed if a field or the struct end is misaligned in
+// Go.
+func (g *gen) layout(t *ir.StructOrUnionType) *goLayout {
+	if l := g.layouts[t.ID()]; l != nil {
+		return l
+	}
+
+	l := &goLayout{align: 1}
+	g.layouts[t.ID()] = l
+	pads := make([]int64, len(t.Fields))
+	var off int64
+	a := int64(1)
+	f := g.goFields(t)
+	for i, v := range g.model.Layout(t)[:len(f)] {
+		fa := g.goAlign(f[i])
+		if off%fa != 0 {
+			off += fa - off%fa
+		}
+		if v.Offset < off {
+			return l
+		}
+
+		pads[i] = v.Offset - off
+		off = v.Offset + v.Size
+		if fa > a {
+			a = fa
+		}
+	}
+	if off%a != 0 {
+		off += a - off%a
+	}
+	sz := g.model.Sizeof(t)
+	if sz < off {
+		return l
+	}
+
+	*l = goLayout{align: a, pads: pads, tail: sz - off}
+	return l
+}
+
+// packed reports whether struct type t cannot be laid out by Go as required by
+// the memory model, like a packed C struct. Such structs are represented as
+// byte arrays and their fields are accessed by methods returning pointers to
+// them.
+func (g *gen) packed(t *ir.StructOrUnionType) bool {
+	if t.Kind() != ir.Struct {
+		return false
+	}
+
+	return g.layout(t).pads == nil
+}
+
+// flexible reports whether the last field of struct type t is a flexible
+// array member or another zero sized field. Go pads a struct ending in a zero
+// sized field, so the field is left out of the Go struct and accessed by a
+// method returning a pointer past the preceding fields.
+func (g *gen) flexible(t *ir.StructOrUnionType) bool {
+	n := len(t.Fields)
+	return t.Kind() == ir.Struct && n != 0 && g.model.Sizeof(t.Fields[n-1]) == 0 && g.model.Sizeof(t) != 0
+}
+
+// goFields returns the fields of struct type t present in its Go struct type.
+func (g *gen) goFields(t *ir.StructOrUnionType) []ir.Type {
+	if g.flexible(t) {
+		return t.Fields[:len(t.Fields)-1]
+	}
+
+	return t.Fields
+}
+
+// accessor reports whether field i of struct or union type t is accessed by
+// a method.
+func (g *gen) accessor(t *ir.StructOrUnionType, i int) bool {
+	return t.Kind() == ir.Union || g.packed(t) || i == len(t.Fields)-1 && g.flexible(t)
+}
+
+// accessors writes the field access methods of the union, packed struct or
+// flexible struct type nm.
+func (g *gen) accessors(nm string, t ir.Type) {
+	switch x := t.(type) {
+	case *ir.StructOrUnionType:
+		switch {
+		case t.Kind() == ir.Union:
+			for i, v := range x.Fields {
+				g.w("func (p *%s) X%s() *%v { return (*%[3]v)(unsafe.Pointer(p)) }\n", nm, g.fld(t.ID(), i), g.typ(v))
+			}
+		default:
+			for i, v := range g.model.Layout(x) {
+				ft := g.typ(x.Fields[i])
+				switch {
+				case i == len(x.Fields)-1 && g.flexible(x):
+					g.w("func (p *%s) X%s() *%v { return (*%[3]v)(unsafe.Pointer(uintptr(unsafe.Pointer(p))+%v)) }\n", nm, g.fld(t.ID(), i), ft, v.Offset)
+				case g.packed(x):
+					g.w("func (p *%s) X%s() *%v { return (*%[3]v)(unsafe.Pointer(&p[%v])) }\n", nm, g.fld(t.ID(), i), ft, v.Offset)
+				}
+			}
+		}
+	}
+}
+
+// layoutAsserts writes declarations failing to compile when the Go layout of
+// the type nm differs from the memory model layout of t.
+func (g *gen) layoutAsserts(nm string, t ir.Type) {
+	switch x := t.(type) {
+	case *ir.StructOrUnionType:
+		if len(x.Fields) == 0 || g.portable && !isPortable(t) {
+			return
+		}
+
+		if g.packed(x) {
+			return
+		}
+
+		if t.Kind() == ir.Struct {
+			for i, v := range g.model.Layout(x)[:len(g.goFields(x))] {
+				if v.Offset != 0 {
+					g.w("var _ = [1]struct{}{}[unsafe.Offsetof(%s{}.X%s)-%v]\n", nm, g.fld(t.ID(), i), v.Offset)
+				}
+			}
+		}
+		g.w("var _ = [1]struct{}{}[unsafe.Sizeof(%s{})-%v]\n", nm, g.model.Sizeof(t))
+	}
+}
+
+// unionSize returns the length of the byte array holding a value of union type
+// t. In portable mode it's the size of the union member largest on all
+// supported architectures. Go pads the array to the alignment of the union.
+func (g *gen) unionSize(t *ir.StructOrUnionType) string {
+	if !g.portable || isPortable(t) {
+		return fmt.Sprint(g.model.Sizeof(t))
+	}
+
+	for _, v := range t.Fields {
+		if lowered32.Sizeof(v) == lowered32.Sizeof(t) && lowered64.Sizeof(v) == lowered64.Sizeof(t) {
+			return g.sizeof(v)
+		}
+	}
+
+	g.unsupported(token.Position{}, t, "portable size of union type %v", t)
+	panic("internal error")
+}
+
+// fieldPath returns the Go selectors and indices of the object of type t at
+// offset off or false if off cannot be expressed that way.
+func (g *gen) fieldPath(t ir.Type, off int64) (string, bool) {
+	var buf bytes.Buffer
+	for off != 0 {
+		switch t.Kind() {
+		case ir.Array:
+			at := t.(*ir.ArrayType)
+			sz := g.model.Sizeof(at.Item)
+			if sz == 0 || off/sz >= at.Items {
+				return "", false
+			}
+
+			fmt.Fprintf(&buf, "[%v]", off/sz)
+			off %= sz
+			t = at.Item
+		case ir.Struct:
+			st := t.(*ir.StructOrUnionType)
+			if g.packed(st) {
+				return "", false
+			}
+
+			i := -1
+			for j, v := range g.model.Layout(st) {
+				if v.Offset <= off && off < v.Offset+v.Size {
+					i = j
+					break
+				}
+			}
+			if i < 0 {
+				return "", false
+			}
+
+			fmt.Fprintf(&buf, ".X%s", g.fld(st.ID(), i))
+			off -= g.model.Layout(st)[i].Offset
+			t = st.Fields[i]
+		default:
+			return "", false
+		}
+	}
+	return buf.String(), true
+}
+
 func (g *gen) isBuiltin(i int) bool {
 	if _, ok := g.builtins[i]; ok {
 		return true
 	}
 
-	f := g.obj[i]
-	if x, ok := f.(*ir.FunctionDefinition); ok && len(x.Body) == 1 {
-		if _, ok := x.Body[0].(*ir.Panic); ok {
-			g.builtins[i] = struct{}{}
-			return true
-		}
+	// Functions of other generated packages are declarations too, but they
+	// are not provided by the C runtime.
+	if x, ok := g.obj[i].(*ir.FunctionDefinition); ok && x.Package == 0 && isDeclaration(x) {
+		g.builtins[i] = struct{}{}
+		return true
 	}
 
 	return false
@@ -389,7 +756,7 @@ func (g *gen) wstring(n ir.StringID) int {
 	}
 
 	ws := []rune(string(dict.S(int(n))))
-	sz := roundup(4*(len(ws)+1), mallocAllign)
+	sz := roundup(4*(len(ws)+1), 2*int(g.model[ir.Pointer].Size))
 	b := make([]byte, sz)
 	for i, v := range ws {
 		*(*rune)(unsafe.Pointer(&b[4*i])) = v //TODO Windows UTF-16
@@ -397,34 +764,6 @@ func (g *gen) wstring(n ir.StringID) int {
 	return g.string(ir.StringID(dict.ID(b)))
 }
 
-func (g *gen) collectLabels(nodes []*node) {
-	g.lblUsed = map[int]int{}
-	for _, v := range nodes {
-		for _, op := range v.Ops {
-			switch x := op.(type) {
-			case *expr:
-				switch y := x.Expr.Op.(type) {
-				case *ir.Jnz:
-					g.lblUsed[g.labels[label2(y.NameID, y.Number)]]++
-				case *ir.Jz:
-					g.lblUsed[g.labels[label2(y.NameID, y.Number)]]++
-				case *ir.Switch:
-					for _, v := range y.Labels {
-						g.lblUsed[g.labels[label2(v.NameID, v.Number)]]++
-					}
-					g.lblUsed[g.labels[label2(y.Default.NameID, y.Default.Number)]]++
-				}
-			case *ir.Jmp:
-				if x.Cond {
-					break
-				}
-
-				g.lblUsed[g.labels[label2(x.NameID, x.Number)]]++
-			}
-		}
-	}
-}
-
 func (g *gen) call(ft *ir.FunctionType, args []*exprNode) {
 	g.w("(tls")
 	for i, v := range args {
@@ -503,9 +842,9 @@ func (g *gen) pcmp(n *exprNode, op string) {
 	}
 
 	// >=, >, <=, <
-	g.w("%s.P2U(", crt)
+	g.w("%s.P2U(", g.crt)
 	g.convert(n.Childs[0], idVoidPtr)
-	g.w(")%s %s.P2U(", op, crt)
+	g.w(")%s %s.P2U(", op, g.crt)
 	g.convert(n.Childs[1], idVoidPtr)
 	g.w(")")
 }
@@ -604,7 +943,7 @@ func (g *gen) binop(n *exprNode) {
 					case uintptr:
 						k = int64(y)
 					default:
-						TODO("%s: %T", x.Position, y)
+						g.unsupported(x.Position, x, "pointer arithmetic scale of type %T", y)
 					}
 					if k == sz {
 						g.elems[t.ID()] = struct{}{}
@@ -630,16 +969,16 @@ func (g *gen) binop(n *exprNode) {
 				return
 			}
 		case *ir.Mul:
-			if isZeroExpr(n.Childs[0]) || isZeroExpr(n.Childs[1]) {
-				TODO("%s: %T", n.Op.Pos(), n.Op)
-			}
-
-			if isOne(n.Childs[0]) {
-				TODO("%s: %T", n.Op.Pos(), n.Op)
-			}
-
-			if isOne(n.Childs[1]) {
-				TODO("%s: %T", n.Op.Pos(), n.Op)
+			switch {
+			case isZeroExpr(n.Childs[0]) || isZeroExpr(n.Childs[1]):
+				g.w("uintptr(0)")
+				return
+			case isOne(n.Childs[0]):
+				g.uintptr(n.Childs[1])
+				return
+			case isOne(n.Childs[1]):
+				g.uintptr(n.Childs[0])
+				return
 			}
 
 			g.uintptr(n.Childs[0])
@@ -666,7 +1005,7 @@ func (g *gen) binop(n *exprNode) {
 		case *ir.Sub:
 			g.w("-")
 		default:
-			TODO("%s: %T", n.Op.Pos(), x)
+			g.unsupported(n.Op.Pos(), x, "pointer operation")
 		}
 		g.uintptr(n.Childs[1])
 		g.w("))")
@@ -692,7 +1031,7 @@ func (g *gen) binop(n *exprNode) {
 	case *ir.Xor:
 		g.w("^")
 	default:
-		TODO("%s: %T", n.Op.Pos(), x)
+		g.unsupported(n.Op.Pos(), x, "binary operation")
 	}
 	g.expression(n.Childs[1], false)
 }
@@ -705,7 +1044,7 @@ func (g *gen) shift(n *exprNode) {
 	case *ir.Rsh:
 		g.w(">>")
 	default:
-		TODO("%s: %T", n.Op.Pos(), x)
+		g.unsupported(n.Op.Pos(), x, "shift operation")
 	}
 	g.uint(n.Childs[1])
 }
@@ -740,7 +1079,7 @@ func (g *gen) num(n *exprNode) interface{} {
 				return uintptr(x.Value)
 			}
 
-			TODO("%s: %v", x.Position, x.TypeID)
+			g.unsupported(x.Position, x, "constant of type %v", x.TypeID)
 		}
 	case *ir.Const64:
 		switch x.TypeID {
@@ -748,10 +1087,12 @@ func (g *gen) num(n *exprNode) interface{} {
 			return x.Value
 		case idUint64:
 			return uint64(x.Value)
-		case idFloat64:
+		case idFloat64, idFloat128:
 			return math.Float64frombits(uint64(x.Value))
+		case idComplex64:
+			return complex64Bits(x.Value)
 		default:
-			TODO("%s: %v", x.Position, x.TypeID)
+			g.unsupported(x.Position, x, "constant of type %v", x.TypeID)
 		}
 	case *ir.Convert:
 		to := g.tc.MustType(x.Result)
@@ -763,7 +1104,7 @@ func (g *gen) num(n *exprNode) interface{} {
 			case ir.Pointer:
 				return uintptr(x)
 			default:
-				TODO("%s: internal error: %v", n.Op.Pos(), to)
+				g.unsupported(n.Op.Pos(), n.Op, "constant conversion to %v", to)
 			}
 		case int64:
 			switch to.Kind() {
@@ -772,14 +1113,14 @@ func (g *gen) num(n *exprNode) interface{} {
 			case ir.Pointer:
 				return uintptr(x)
 			default:
-				TODO("%s: internal error: %v", n.Op.Pos(), to)
+				g.unsupported(n.Op.Pos(), n.Op, "constant conversion to %v", to)
 			}
 		case uint32:
 			switch to.Kind() {
 			case ir.Int32:
 				return int32(x)
 			default:
-				TODO("%s: internal error: %v", n.Op.Pos(), to)
+				g.unsupported(n.Op.Pos(), n.Op, "constant conversion to %v", to)
 			}
 		case uint64:
 			switch to.Kind() {
@@ -788,13 +1129,13 @@ func (g *gen) num(n *exprNode) interface{} {
 			case ir.Pointer:
 				return uintptr(x)
 			default:
-				TODO("%s: internal error: %v", n.Op.Pos(), to)
+				g.unsupported(n.Op.Pos(), n.Op, "constant conversion to %v", to)
 			}
 		default:
-			TODO("%s: internal error: %T", n.Op.Pos(), x)
+			g.unsupported(n.Op.Pos(), n.Op, "constant conversion of %T", x)
 		}
 	default:
-		TODO("%s: internal error: %T", x.Pos(), x)
+		g.unsupported(x.Pos(), x, "constant expression")
 	}
 	panic("internal error")
 }
@@ -817,8 +1158,17 @@ func (g *gen) uint(n *exprNode) {
 	case int64:
 		g.w("%v", x)
 	default:
-		TODO("%s: %T", n.Op.Pos(), x)
+		g.unsupported(n.Op.Pos(), n.Op, "shift count of type %T", x)
+	}
+}
+
+// uintptrValue returns n truncated to the size of the target's uintptr.
+func (g *gen) uintptrValue(n uint64) uint64 {
+	if g.model[ir.Pointer].Size < 8 {
+		return uint64(uint32(n))
 	}
+
+	return n
 }
 
 func (g *gen) uintptr(n *exprNode) { g.uintptr2(n, 1) }
@@ -852,7 +1202,8 @@ func (g *gen) uintptr2(n *exprNode, sgn int) {
 				g.uintptr2(n.Childs[0], sgn)
 			case *ir.Mul:
 				if isZeroExpr(n.Childs[0]) || isZeroExpr(n.Childs[1]) {
-					TODO("%s: %T", n.Op.Pos(), n.Op)
+					g.w("0")
+					return
 				}
 
 				if isOne(n.Childs[0]) {
@@ -861,7 +1212,8 @@ func (g *gen) uintptr2(n *exprNode, sgn int) {
 				}
 
 				if isOne(n.Childs[1]) {
-					TODO("%s: %T", n.Op.Pos(), n.Op)
+					g.uintptr2(n.Childs[0], sgn)
+					return
 				}
 
 				g.uintptr2(n.Childs[0], sgn)
@@ -879,24 +1231,24 @@ func (g *gen) uintptr2(n *exprNode, sgn int) {
 			}
 			return
 		default:
-			TODO("%s: %v", n.Op.Pos(), t)
+			g.unsupported(n.Op.Pos(), n.Op, "pointer arithmetic operand of type %v", t)
 		}
 	}
 
-	us := uintptr(sgn)
+	us := uint64(sgn)
 	switch x := g.num(n).(type) {
 	case int32:
-		g.w("uintptr(%v)", us*uintptr(x))
+		g.w("uintptr(%v)", g.uintptrValue(us*uint64(x)))
 	case uint32:
-		g.w("uintptr(%v)", us*uintptr(x))
+		g.w("uintptr(%v)", g.uintptrValue(us*uint64(x)))
 	case int64:
-		g.w("uintptr(%v)", us*uintptr(x))
+		g.w("uintptr(%v)", g.uintptrValue(us*uint64(x)))
 	case uint64:
-		g.w("uintptr(%v)", us*uintptr(x))
+		g.w("uintptr(%v)", g.uintptrValue(us*x))
 	case uintptr:
-		g.w("uintptr(%v)", us*x)
+		g.w("uintptr(%v)", g.uintptrValue(us*uint64(x)))
 	default:
-		TODO("%s: %T", n.Op.Pos(), x)
+		g.unsupported(n.Op.Pos(), n.Op, "pointer arithmetic constant of type %T", x)
 	}
 }
 
@@ -911,7 +1263,7 @@ func (g *gen) int8(n *exprNode) {
 	case float64:
 		g.w("int8(%v)", int8(x))
 	default:
-		TODO("%s: %T", n.Op.Pos(), x)
+		g.unsupported(n.Op.Pos(), n.Op, "conversion of constant %v of type %T to int8", x, x)
 	}
 }
 
@@ -922,7 +1274,7 @@ func (g *gen) uint8(n *exprNode) {
 	case int64:
 		g.w("uint8(%v)", uint8(x))
 	default:
-		TODO("%s: %T", n.Op.Pos(), x)
+		g.unsupported(n.Op.Pos(), n.Op, "conversion of constant %v of type %T to uint8", x, x)
 	}
 }
 
@@ -933,7 +1285,7 @@ func (g *gen) int16(n *exprNode) {
 	case int64:
 		g.w("int16(%v)", int16(x))
 	default:
-		TODO("%s: %T", n.Op.Pos(), x)
+		g.unsupported(n.Op.Pos(), n.Op, "conversion of constant %v of type %T to int16", x, x)
 	}
 }
 
@@ -948,7 +1300,7 @@ func (g *gen) uint16(n *exprNode) {
 	case uint64:
 		g.w("uint16(%v)", uint16(x))
 	default:
-		TODO("%s: %T", n.Op.Pos(), x)
+		g.unsupported(n.Op.Pos(), n.Op, "conversion of constant %v of type %T to uint16", x, x)
 	}
 }
 
@@ -973,7 +1325,7 @@ func (g *gen) int32(n *exprNode) {
 	case float64:
 		g.w("int32(%v)", int32(x))
 	default:
-		TODO("%s: %T", n.Op.Pos(), x)
+		g.unsupported(n.Op.Pos(), n.Op, "conversion of constant %v of type %T to int32", x, x)
 	}
 }
 
@@ -990,7 +1342,7 @@ func (g *gen) uint32(n *exprNode) {
 	case uint64:
 		g.w("uint32(%v)", uint32(x))
 	default:
-		TODO("%s: %T", n.Op.Pos(), x)
+		g.unsupported(n.Op.Pos(), n.Op, "conversion of constant %v of type %T to uint32", x, x)
 	}
 }
 
@@ -1005,7 +1357,7 @@ func (g *gen) int64(n *exprNode) {
 	case uint64:
 		g.w("int64(%v)", int64(x))
 	default:
-		TODO("%s: %T", n.Op.Pos(), x)
+		g.unsupported(n.Op.Pos(), n.Op, "conversion of constant %v of type %T to int64", x, x)
 	}
 }
 
@@ -1020,7 +1372,7 @@ func (g *gen) uint64(n *exprNode) {
 	case int64:
 		g.w("uint64(%v)", uint64(x))
 	default:
-		TODO("%s: %T", n.Op.Pos(), x)
+		g.unsupported(n.Op.Pos(), n.Op, "conversion of constant %v of type %T to uint64", x, x)
 	}
 }
 
@@ -1033,7 +1385,7 @@ func (g *gen) float32(n *exprNode) {
 	case float64:
 		g.w("float32(%v)", float32(x))
 	default:
-		TODO("%s: %T", n.Op.Pos(), x)
+		g.unsupported(n.Op.Pos(), n.Op, "conversion of constant %v of type %T to float32", x, x)
 	}
 }
 
@@ -1044,24 +1396,57 @@ func (g *gen) float64(n *exprNode) {
 	case int64:
 		g.w("%v", float64(x))
 	default:
-		TODO("%s: %T", n.Op.Pos(), x)
+		g.unsupported(n.Op.Pos(), n.Op, "conversion of constant %v of type %T to float64", x, x)
 	}
 }
 
-func (g *gen) complex64(n *exprNode) {
+func (g *gen) complex64(n *exprNode) { g.complexConst(idComplex64, g.complexNum(n)) }
+
+func (g *gen) complex128(n *exprNode) { g.complexConst(idComplex128, g.complexNum(n)) }
+
+// complexNum returns the value of the constant n.
+func (g *gen) complexNum(n *exprNode) complex128 {
 	switch x := g.num(n).(type) {
+	case int8:
+		return complex(float64(x), 0)
+	case uint8:
+		return complex(float64(x), 0)
+	case int16:
+		return complex(float64(x), 0)
+	case uint16:
+		return complex(float64(x), 0)
 	case int32:
-		g.w("complex(float32(%v), 0) ", x)
+		return complex(float64(x), 0)
+	case uint32:
+		return complex(float64(x), 0)
+	case int64:
+		return complex(float64(x), 0)
+	case uint64:
+		return complex(float64(x), 0)
+	case float64:
+		return complex(x, 0)
+	case complex128:
+		return x
 	default:
-		TODO("%s: %T", n.Op.Pos(), x)
+		g.unsupported(n.Op.Pos(), n.Op, "conversion of constant %v of type %T to complex", x, x)
+	}
+	panic("internal error")
+}
+
+// complexConst writes the complex constant v of type t.
+func (g *gen) complexConst(t ir.TypeID, v complex128) {
+	f := "float64"
+	if g.tc.MustType(t).Kind() == ir.Complex64 {
+		f = "float32"
 	}
+	g.w("complex(%[1]s(%[2]s), %[1]s(%[3]s))", f, floatLit(real(v)), floatLit(imag(v)))
 }
 
 func (g *gen) convConst(to ir.TypeID, n *exprNode) {
 	if isZeroExpr(n) {
 		switch {
 		case g.tc.MustType(to).Kind() == ir.Pointer:
-			g.w("nil")
+			g.null(g.tc.MustType(to))
 		default:
 			g.w("0")
 		}
@@ -1087,19 +1472,28 @@ func (g *gen) convConst(to ir.TypeID, n *exprNode) {
 		g.uint64(n)
 	case idFloat32:
 		g.float32(n)
-	case idFloat64:
+	case idFloat64, idFloat128:
 		g.float64(n)
 	case idComplex64:
 		g.complex64(n)
+	case idComplex128, idComplex256:
+		g.complex128(n)
 	default:
-		TODO("%s: internal error: %v", n.Op.Pos(), to)
-		panic("internal error")
+		g.unsupported(n.Op.Pos(), n.Op, "constant conversion to %v", to)
+	}
+}
+
+// relink restores the comma chain a unlinked for writing its parts, so the
+// expression can be written again.
+func relink(a []*exprNode) {
+	for i := 1; i < len(a); i++ {
+		a[i-1].Comma = a[i]
 	}
 }
 
-func (g *gen) expression(n *exprNode, void bool) bool { return g.expression2(n, void, 0) }
+func (g *gen) expression(n *exprNode, void bool) {
+	defer g.unsupportedExpr()
 
-func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 	switch n.Op.(type) {
 	case
 		*ir.Drop,
@@ -1124,11 +1518,12 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 	}
 	if len(a) != 0 {
 		if n.TypeID == 0 {
-			TODO("%s:\n%s", n.Op.Pos(), pretty(n))
+			g.unsupported(n.Op.Pos(), n.Op, "comma expression without a type")
 		}
 		for _, v := range a {
 			v.Comma = nil
 		}
+		defer relink(a)
 		switch {
 		case void:
 			for i := len(a) - 1; i >= 0; i-- {
@@ -1137,7 +1532,7 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 			}
 			switch n.Op.(type) {
 			case *ir.Const32:
-				return false
+				return
 			}
 
 			g.w("_ = ")
@@ -1157,7 +1552,7 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 	switch x := n.Op.(type) {
 	case *ir.Argument:
 		if void {
-			return false
+			return
 		}
 
 		if x.Address {
@@ -1186,17 +1581,62 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 		g.w(")")
 	case *ir.Call:
 		f := g.obj[x.Index].(*ir.FunctionDefinition)
+		ft := g.tc.MustType(f.TypeID).(*ir.FunctionType)
+		if g.isBuiltin(x.Index) && g.complexBuiltin(f.NameID, ft, n.Childs) {
+			break
+		}
+
 		if g.isBuiltin(x.Index) {
-			g.w("%s.", crt)
+			g.w("%s.", g.crt)
 		}
-		g.w("%s", g.mangle2(f.Package, f.NameID, f.Linkage == ir.ExternalLinkage, -1))
-		ft := g.tc.MustType(f.TypeID).(*ir.FunctionType)
+		g.w("%s", g.global(x.Pos(), x, x.Index))
 		g.call(ft, n.Childs)
 	case *ir.CallFP:
 		fp := n.Childs[0]
-		g.expression(fp, false)
 		ft := g.tc.MustType(fp.TypeID).(*ir.PointerType).Element.(*ir.FunctionType)
+		g.expression(fp, false)
 		g.call(ft, n.Childs[1:])
+	case *spilled:
+		g.f.varNfo[x.index].w++
+		g.w("_%v = ", x.index)
+		g.convert(n.Childs[0], g.f.varNfo[x.index].def.TypeID)
+	case *results:
+		used := false
+		for _, v := range x.temps {
+			used = used || v >= 0
+		}
+		if !used {
+			g.expression(n.Childs[0], true)
+			break
+		}
+
+		for i, v := range x.temps {
+			if i != 0 {
+				g.w(", ")
+			}
+			switch {
+			case v < 0:
+				g.w("_")
+			default:
+				g.f.varNfo[v].w++
+				g.w("_%v", v)
+			}
+		}
+		g.w(" = ")
+		g.expression(n.Childs[0], true)
+	case *callResult:
+		if void {
+			break
+		}
+
+		g.f.varNfo[x.temps[x.index]].r++
+		g.w("_%v", x.temps[x.index])
+	case *ir.Const:
+		if void {
+			break
+		}
+
+		g.value(x.Pos(), x.TypeID, x.Value)
 	case *ir.Const32:
 		if void {
 			break
@@ -1206,9 +1646,9 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 		case ir.Pointer:
 			switch {
 			case x.Value == 0:
-				TODO("%s: %v", x.Pos(), x.TypeID)
+				g.null(t)
 			default:
-				g.w("(%v)(unsafe.Pointer(uintptr(%v)))", g.typ(t), uintptr(x.Value))
+				g.w("(%v)(unsafe.Pointer(uintptr(%v)))", g.typ(t), g.uintptrValue(uint64(x.Value)))
 			}
 		case ir.Int8:
 			g.w("int8(%v) ", int8(x.Value))
@@ -1229,7 +1669,7 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 		case ir.Float32:
 			g.w("float32(%v) ", math.Float32frombits(uint32(x.Value)))
 		default:
-			TODO("%s: %v", x.Pos(), x.TypeID)
+			g.unsupported(x.Pos(), x, "constant of type %v", x.TypeID)
 		}
 	case *ir.Const64:
 		if void {
@@ -1238,8 +1678,8 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 
 		switch x.TypeID {
 		case idComplex64:
-			g.w("complex(float32(%v), 0) ", math.Float64frombits(uint64(x.Value)))
-		case idFloat64:
+			g.complexConst(x.TypeID, complex64Bits(x.Value))
+		case idFloat64, idFloat128:
 			v := math.Float64frombits(uint64(x.Value))
 			switch {
 			case math.IsInf(v, 0):
@@ -1266,13 +1706,26 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 		case idUint64:
 			g.w("uint64(%v) ", uint64(x.Value))
 		default:
-			TODO("%s: %v", x.Pos(), x.TypeID)
+			switch t := g.tc.MustType(x.TypeID); {
+			case t.Kind() != ir.Pointer:
+				g.unsupported(x.Pos(), x, "constant of type %v", x.TypeID)
+			case x.Value == 0:
+				g.null(t)
+			default:
+				g.w("(%v)(unsafe.Pointer(uintptr(%v)))", g.typ(t), g.uintptrValue(uint64(x.Value)))
+			}
+		}
+	case *ir.ConstC128:
+		if void {
+			break
 		}
+
+		g.complexConst(x.TypeID, x.Value)
 	case *ir.Convert:
 		e := n.Childs[0]
 		if void && e.TypeID != idVaList {
 			g.expression(e, true)
-			return false
+			return
 		}
 
 		to := g.tc.MustType(x.Result)
@@ -1301,7 +1754,7 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 			g.expression(n.Childs[0], false)
 			g.w("= *")
 			g.expression(n.Childs[1], false)
-			return false
+			return
 		}
 
 		g.copies[x.TypeID] = struct{}{}
@@ -1317,7 +1770,7 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 		g.expression(n.Childs[0], true)
 	case *ir.Element:
 		if g.isIntptr(n.Childs[0]) {
-			TODO("%s", x.Position)
+			g.unsupported(x.Pos(), x, "indexing an integer converted to a pointer")
 		}
 
 		if x, ok := n.Childs[0].Op.(*ir.Variable); ok {
@@ -1357,16 +1810,25 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 			nfo.r++
 		}
 		t := g.tc.MustType(x.TypeID).(*ir.PointerType).Element.(*ir.StructOrUnionType)
-		ft := t.Fields[x.Index]
 		switch t.Kind() {
 		case ir.Union:
 			if !x.Address {
 				g.w("*")
 			}
-			g.w("(*%v)(unsafe.Pointer", g.typ(ft))
+			g.w("(")
 			g.convert(e, x.TypeID)
-			g.w(")")
+			g.w(").X%s()", g.fld(t.ID(), x.Index))
 		default:
+			if g.accessor(t, x.Index) {
+				if !x.Address {
+					g.w("*")
+				}
+				g.w("(")
+				g.expression(e, false)
+				g.w(").X%s()", g.fld(t.ID(), x.Index))
+				break
+			}
+
 			if x.Address {
 				g.w("&")
 			}
@@ -1376,58 +1838,34 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 			g.w(".X%s)", g.fld(t.ID(), x.Index))
 		}
 	case *ir.Global:
-		nm := g.mangle(x.NameID, x.Linkage == ir.ExternalLinkage, -1)
+		nm := g.global(x.Pos(), x, x.Index)
 		s := ""
 		if g.isBuiltin(x.Index) {
-			s = fmt.Sprintf("%s.", crt)
+			s = fmt.Sprintf("%s.", g.crt)
 		}
 		if x.Address {
 			switch t := g.tc.MustType(x.TypeID); t.Kind() {
 			case ir.Pointer:
 				if t.(*ir.PointerType).Element.Kind() == ir.Function {
 					g.w("%s%s", s, nm)
-					return false
+					return
 				}
 
 				g.w("&%s", nm)
-				return false
+				return
 			default:
-				TODO("%s: %T\n%s", x.Pos(), x, x.TypeID)
+				g.unsupported(x.Pos(), x, "address of a global of type %v", x.TypeID)
 			}
 		}
 
 		g.w("%s%s", s, nm)
 	case *ir.Jnz:
-		l := int(x.NameID)
-		if l == 0 {
-			l = -x.Number
-		}
-		if nextLabel == l {
-			g.w("if")
-			g.expression(n.Childs[0], false)
-			g.w("== 0 {")
-			g.lblUsed[g.labels[l]]--
-			return true
-		}
-
 		g.w("if")
 		g.expression(n.Childs[0], false)
 		g.w("!= 0 { goto ")
 		g.label(x.NameID, x.Number)
 		g.w("}")
 	case *ir.Jz:
-		l := int(x.NameID)
-		if l == 0 {
-			l = -x.Number
-		}
-		if nextLabel == l {
-			g.w("if")
-			g.expression(n.Childs[0], false)
-			g.w("!= 0 {")
-			g.lblUsed[g.labels[l]]--
-			return true
-		}
-
 		g.w("if")
 		g.expression(n.Childs[0], false)
 		g.w("== 0 { goto ")
@@ -1448,7 +1886,7 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 					g.w("== 0 {")
 					g.expression(n.Childs[2], true)
 					g.w("}}()")
-					return false
+					return
 				}
 			}
 
@@ -1464,7 +1902,7 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 			g.w("}; return")
 			g.expression(n.Childs[2], false)
 			g.w("}()")
-			return false
+			return
 		}
 
 		g.w("bool2int(")
@@ -1484,7 +1922,7 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 		g.w("*")
 		if _, ok := n.Childs[0].Op.(*ir.Dup); ok {
 			g.w("p")
-			return false
+			return
 		}
 
 		g.expression(n.Childs[0], false)
@@ -1492,128 +1930,20 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 		g.w("-")
 		g.expression(n.Childs[0], false)
 	case *ir.Nil:
-		g.w("nil")
+		g.null(t)
 	case *ir.Not:
 		g.w("bool2int(")
 		g.expression(n.Childs[0], false)
 		g.w("== 0)")
 	case *ir.PostIncrement:
-		if x.Bits != 0 {
-			TODO("%s", x.Pos())
-		}
-
-		if void {
-			switch {
-			case t.Kind() == ir.Pointer:
-				g.w("*(*uintptr)(")
-				g.convert(n.Childs[0], idVoidPtr)
-				g.w(") += uintptr(%v)", uintptr(x.Delta))
-			default:
-				g.w("*")
-				g.expression(n.Childs[0], false)
-				switch {
-				case x.Delta < 0:
-					g.w("-= %v", -x.Delta)
-				default:
-					g.w("+= %v", x.Delta)
-				}
-			}
-			return false
-		}
-
-		g.postIncs[x.TypeID] = struct{}{}
-		g.w("postInc%d(", g.reg(x.TypeID))
-		g.expression(n.Childs[0], false)
-		switch {
-		case g.tc.MustType(x.TypeID).Kind() == ir.Pointer:
-			g.w(", %v)", x.Delta)
-		default:
-			switch x.TypeID {
-			case idInt8:
-				g.w(", %v)", int8(x.Delta))
-			case idUint8:
-				g.w(", byte(%v))", uint8(x.Delta))
-			case idInt16:
-				g.w(", %v)", int16(x.Delta))
-			case idUint16:
-				g.w(", uint16(%v))", uint16(x.Delta))
-			case idInt32:
-				g.w(", %v)", int32(x.Delta))
-			case idUint32:
-				g.w(", uint32(%v))", uint32(x.Delta))
-			case idInt64:
-				g.w(", %v)", int64(x.Delta))
-			case idUint64:
-				g.w(", uint64(%v))", uint64(x.Delta))
-			case idFloat32:
-				g.w(", %v)", float32(x.Delta))
-			case idFloat64:
-				g.w(", %v)", float64(x.Delta))
-			default:
-				TODO("%s: %v", x.Pos(), x.TypeID)
-			}
-		}
-	case *ir.PreIncrement:
-		if void {
-			switch {
-			case t.Kind() == ir.Pointer:
-				g.w("*(*uintptr)(")
-				g.convert(n.Childs[0], idVoidPtr)
-				g.w(") += uintptr(%v)", uintptr(x.Delta))
-			default:
-				g.w("*")
-				g.expression(n.Childs[0], false)
-				switch {
-				case x.Delta < 0:
-					g.w("-= %v", -x.Delta)
-				default:
-					g.w("+= %v", x.Delta)
-				}
-			}
-			return false
-		}
-
-		g.preIncs[x.TypeID] = struct{}{}
-		if x.Bits != 0 {
-			TODO("%s", x.Pos())
-		}
-
-		g.w("preInc%d(", g.reg(x.TypeID))
-		g.expression(n.Childs[0], false)
-		switch {
-		case g.tc.MustType(x.TypeID).Kind() == ir.Pointer:
-			g.w(", %v)", x.Delta)
-		default:
-			switch x.TypeID {
-			case idInt8:
-				g.w(", %v)", int8(x.Delta))
-			case idUint8:
-				g.w(", byte(%v))", uint8(x.Delta))
-			case idInt16:
-				g.w(", %v)", int16(x.Delta))
-			case idUint16:
-				g.w(", uint16(%v))", uint16(x.Delta))
-			case idInt32:
-				g.w(", %v)", int32(x.Delta))
-			case idUint32:
-				g.w(", uint32(%v))", uint32(x.Delta))
-			case idInt64:
-				g.w(", %v)", int64(x.Delta))
-			case idUint64:
-				g.w(", uint64(%v))", uint64(x.Delta))
-			case idFloat32:
-				g.w(", %v)", float32(x.Delta))
-			case idFloat64:
-				g.w(", %v)", float64(x.Delta))
-			default:
-				TODO("%s: %v", x.Pos(), x.TypeID)
-			}
-		}
-	case *ir.PtrDiff:
-		sz := g.model.Sizeof(g.tc.MustType(x.PtrType).(*ir.PointerType).Element)
-		g.w("%v((", x.TypeID)
-		if sz != 1 {
-			g.w("(")
+		g.increment(n, void, x, x.TypeID, x.BitFieldType, x.Bits, x.BitOffset, x.Delta, false)
+	case *ir.PreIncrement:
+		g.increment(n, void, x, x.TypeID, x.BitFieldType, x.Bits, x.BitOffset, x.Delta, true)
+	case *ir.PtrDiff:
+		sz := g.model.Sizeof(g.tc.MustType(x.PtrType).(*ir.PointerType).Element)
+		g.w("%v((", x.TypeID)
+		if sz != 1 {
+			g.w("(")
 		}
 		g.w("uintptr(")
 		g.convert(n.Childs[0], idVoidPtr)
@@ -1625,7 +1955,7 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 		}
 		g.w(")")
 		if sz != 1 {
-			g.w("/%v)", sz)
+			g.w("/%v)", g.sizeof(g.tc.MustType(x.PtrType).(*ir.PointerType).Element))
 		}
 		g.w(")")
 	case *ir.Result:
@@ -1653,7 +1983,7 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 			g.expression(n.Childs[1], false)
 			g.w("}()")
 			g.w(")")
-			return false
+			return
 		case x.Bits == 0 && void && !asop:
 			g.w("*")
 			g.expression(n.Childs[0], false)
@@ -1692,11 +2022,10 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 				g.w(" }")
 			case *ir.Element:
 				if !x.Address {
-					TODO("%s", x.Position)
+					g.unsupported(x.Pos(), x, "compound assignment to an element value")
 				}
 
-				t := g.tc.MustType(x.TypeID)
-				sz := g.model.Sizeof(t.(*ir.PointerType).Element)
+				et := g.tc.MustType(x.TypeID).(*ir.PointerType).Element
 				s := "+"
 				if x.Neg {
 					s = "-"
@@ -1704,8 +2033,8 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 				g.w("*(*uintptr)(")
 				g.convert(n.Childs[0].Childs[0], idVoidPtr)
 				s2 := ""
-				if sz != 1 {
-					s2 = fmt.Sprintf("%v*", sz)
+				if g.model.Sizeof(et) != 1 {
+					s2 = fmt.Sprintf("%v*", g.sizeof(et))
 				}
 				g.w(") %s= %suintptr(", s, s2)
 				g.expression(e.Childs[1], false)
@@ -1743,46 +2072,29 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 				}
 				g.expression(e.Childs[1], false)
 			}
-		case x.Bits != 0 && !void && !asop:
-			m := (uint64(1)<<uint(x.Bits) - 1) << uint(x.BitOffset)
+		case x.Bits != 0 && !asop:
 			g.storebits[x.TypeID] = struct{}{}
 			g.w("storebits%d(", g.reg(x.TypeID))
 			g.expression(n.Childs[0], false)
 			g.w(", ")
 			g.expression(n.Childs[1], false)
-			g.w(", %v, %v)", m, x.BitOffset)
-		case x.Bits != 0 && void && !asop:
-			m := (uint64(1)<<uint(x.Bits) - 1) << uint(x.BitOffset)
+			g.w(", %s)", g.bitField(x.TypeID, x.Bits, x.BitOffset))
+		case x.Bits != 0 && !void && asop:
 			g.storebits[x.TypeID] = struct{}{}
-			g.w("storebits%d(", g.reg(x.TypeID))
-			g.expression(n.Childs[0], false)
-			g.w(", ")
+			g.w("func() %v { p := ", g.typ2(x.TypeID))
+			g.expression(n.Childs[0].Childs[0], false)
+			g.w("; return storebits%d(p, ", g.reg(x.TypeID))
 			g.expression(n.Childs[1], false)
-			g.w(", %v, %v)", m, x.BitOffset)
+			g.w(", %s) }()", g.bitField(x.TypeID, x.Bits, x.BitOffset))
 		case x.Bits != 0 && void && asop:
-			m := (uint64(1)<<uint(x.Bits) - 1) << uint(x.BitOffset)
+			g.storebits[x.TypeID] = struct{}{}
 			g.w("{ p := ")
 			g.expression(n.Childs[0].Childs[0], false)
-			g.w("; v :=")
+			g.w("; storebits%d(p, ", g.reg(x.TypeID))
 			g.expression(n.Childs[1], false)
-			s := ""
-			switch n.Childs[1].TypeID {
-			case idInt8:
-				s = fmt.Sprint(int8(m))
-			case idInt16:
-				s = fmt.Sprint(int16(m))
-			case idInt32:
-				s = fmt.Sprint(int32(m))
-			case idInt64:
-				s = fmt.Sprint(int64(m))
-			default:
-				_ = m
-				TODO("%s: %v", x.Pos(), n.Childs[1].TypeID)
-			}
-			g.w("; *p = *p&^%s|(v<<%v)&%s", s, x.BitOffset, s)
-			g.w("}")
+			g.w(", %s) }", g.bitField(x.TypeID, x.Bits, x.BitOffset))
 		default:
-			TODO("%s: %v %v %v", x.Pos(), x.Bits, void, asop)
+			g.unsupported(x.Pos(), x, "bit field store (bits %v, void %v, asop %v)", x.Bits, void, asop)
 		}
 	case *ir.StringConst:
 		switch x.TypeID {
@@ -1791,11 +2103,11 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 		case idInt32Ptr:
 			g.w("wstr(%v)", g.wstring(x.Value))
 		default:
-			panic(fmt.Errorf("%s: TODO %v", x.Position, x.TypeID))
+			g.unsupported(x.Pos(), x, "string constant of type %v", x.TypeID)
 		}
 	case *ir.Variable:
 		if void {
-			return false
+			return
 		}
 
 		nfo := &g.f.varNfo[x.Index]
@@ -1817,11 +2129,7 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 			g.w("%v", g.mangle(nfo.def.NameID, false, sc))
 		}
 	case *ir.Switch:
-		var a switchPairs
-		for i, v := range x.Values {
-			a = append(a, switchPair{v, &x.Labels[i]})
-		}
-		sort.Sort(a)
+		a := g.switchPairs(x)
 		g.w("switch")
 		g.expression(n.Childs[0], false)
 		g.w("{\n")
@@ -1852,7 +2160,7 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 
 		if void {
 			g.expression(n.Childs[0], true)
-			return false
+			return
 		}
 
 		g.shift(n)
@@ -1866,9 +2174,107 @@ func (g *gen) expression2(n *exprNode, void bool, nextLabel int) bool {
 
 		g.relop(n)
 	default:
-		TODO("%s: %T", x.Pos(), x)
+		g.unsupported(x.Pos(), x, "operation")
+	}
+}
+
+// increment generates a pre or post increment by delta of the value of type t,
+// or of the bit field of type bt within it, pointed to by n.Childs[0].
+func (g *gen) increment(n *exprNode, void bool, op ir.Operation, t, bt ir.TypeID, bits, off, delta int, pre bool) {
+	switch {
+	case bits != 0:
+		nm, m := "postbits", g.postbits
+		if pre {
+			nm, m = "prebits", g.prebits
+		}
+		m[t] = struct{}{}
+		ext := ""
+		if !void {
+			ext = g.bitFieldExt(t, bt, bits)
+			if ext != "" {
+				g.w("(")
+			}
+			g.w("%v", g.typ2(bt))
+		}
+		g.w("(%s%d(", nm, g.reg(t))
+		g.expression(n.Childs[0], false)
+		g.w(", %v, %s))", delta, g.bitField(t, bits, off))
+		if ext != "" {
+			g.w("%s)", ext)
+		}
+	case void:
+		op := "+="
+		if delta < 0 {
+			op = "-="
+			delta = -delta
+		}
+		switch g.tc.MustType(t).Kind() {
+		case ir.Pointer:
+			g.w("*(*uintptr)(")
+			g.convert(n.Childs[0], idVoidPtr)
+			g.w(") %s %v", op, delta)
+		default:
+			g.w("*")
+			g.expression(n.Childs[0], false)
+			g.w("%s %v", op, delta)
+		}
+	default:
+		nm, m := "postInc", g.postIncs
+		if pre {
+			nm, m = "preInc", g.preIncs
+		}
+		m[t] = struct{}{}
+		g.w("%s%d(", nm, g.reg(t))
+		g.expression(n.Childs[0], false)
+		g.w(", %s)", g.incDelta(op, t, delta))
+	}
+}
+
+// incDelta returns delta as a constant assignable to the delta parameter of
+// the increment helper of type t. Integer deltas wrap around.
+func (g *gen) incDelta(op ir.Operation, t ir.TypeID, delta int) string {
+	switch g.tc.MustType(t).Kind() {
+	case ir.Int8:
+		return fmt.Sprint(int8(delta))
+	case ir.Uint8:
+		return fmt.Sprint(uint8(delta))
+	case ir.Int16:
+		return fmt.Sprint(int16(delta))
+	case ir.Uint16:
+		return fmt.Sprint(uint16(delta))
+	case ir.Int32:
+		return fmt.Sprint(int32(delta))
+	case ir.Uint32:
+		return fmt.Sprint(uint32(delta))
+	case ir.Uint64:
+		return fmt.Sprint(uint64(delta))
+	case ir.Int64, ir.Float32, ir.Float64, ir.Float128, ir.Complex64, ir.Complex128, ir.Complex256, ir.Pointer:
+		return fmt.Sprint(delta)
+	default:
+		g.unsupported(op.Pos(), op, "increment of type %v", t)
+	}
+	panic("internal error")
+}
+
+// incHelper generates the increment helper nm of type t. Pointers are
+// incremented by delta bytes. Bit field helpers take the field layout produced
+// by bitField and return the sign or zero extended field value.
+func (g *gen) incHelper(nm string, t ir.TypeID, pre, bits bool) {
+	g.w("func %s%d", nm, g.reg(t))
+	switch T := g.typ2(t); {
+	case bits && pre:
+		g.w("(p *%[1]v, d int, m uint64, o, s uint) %[1]v { v := (*p<<(s-o)>>s + %[1]v(d))<<s>>s; *p = *p&^%[1]v(m)|(v<<o&%[1]v(m)); return v }\n", T)
+	case bits:
+		g.w("(p *%[1]v, d int, m uint64, o, s uint) %[1]v { v := *p<<(s-o)>>s; *p = *p&^%[1]v(m)|((v+%[1]v(d))<<o&%[1]v(m)); return v }\n", T)
+	case g.tc.MustType(t).Kind() == ir.Pointer && pre:
+		g.w("(p *%[1]v, d int) %[1]v { q := (*uintptr)(unsafe.Pointer(p)); v := *q + uintptr(d); *q = v; return (%[1]v)(unsafe.Pointer(v)) }\n", T)
+	case g.tc.MustType(t).Kind() == ir.Pointer:
+		g.w("(p *%[1]v, d int) %[1]v { q := (*uintptr)(unsafe.Pointer(p)); v := *q; *q += uintptr(d); return (%[1]v)(unsafe.Pointer(v)) }\n", T)
+	case pre:
+		g.w("(p *%[1]v, d %[1]v) %[1]v { v := *p + d; *p = v; return v }\n", T)
+	default:
+		g.w("(p *%[1]v, d %[1]v) %[1]v { v := *p; *p += d; return v }\n", T)
 	}
-	return false
 }
 
 func (g *gen) convert(e *exprNode, to ir.TypeID) { g.convert2(e, e.TypeID, to) }
@@ -1895,10 +2301,10 @@ func (g *gen) convert2(e *exprNode, from, to ir.TypeID) {
 				g.w("args")
 				return
 			default:
-				TODO("%s: %v", e.Op.Pos(), x.Value)
+				g.unsupported(e.Op.Pos(), x, "va_list operation %v", x.Value)
 			}
 		default:
-			TODO("%s: %T %v", e.Op.Pos(), x, e.TypeID)
+			g.unsupported(e.Op.Pos(), x, "conversion of %v to va_list", e.TypeID)
 		}
 	}
 
@@ -1906,14 +2312,14 @@ func (g *gen) convert2(e *exprNode, from, to ir.TypeID) {
 		switch t := g.tc.MustType(to); {
 		case t.Kind() == ir.Pointer:
 			if u := t.(*ir.PointerType); u.Element.Kind() == ir.Function {
-				g.w("%s.VAOther(&", crt)
+				g.w("%s.VAOther(&", g.crt)
 				g.expression(e, false)
 				g.w(").(%v)", g.typ(u.Element))
 				break
 			}
 
 			if to != idVoidPtr {
-				g.w("(%v)(%s.VAPointer(&", g.typ(t), crt)
+				g.w("(%v)(%s.VAPointer(&", g.typ(t), g.crt)
 				g.expression(e, false)
 				g.w("))")
 				break
@@ -1923,11 +2329,11 @@ func (g *gen) convert2(e *exprNode, from, to ir.TypeID) {
 		case isIntegralType(to):
 			s := g.tc.MustType(to).Kind().String()
 			s = strings.ToUpper(s[:1]) + s[1:]
-			g.w("%s.VA%s(&", crt, s)
+			g.w("%s.VA%s(&", g.crt, s)
 			g.expression(e, false)
 			g.w(")")
 		default:
-			g.w("%s.VAOther(&", crt)
+			g.w("%s.VAOther(&", g.crt)
 			g.expression(e, false)
 			g.w(").(%v)", g.typ(t))
 		}
@@ -1935,7 +2341,7 @@ func (g *gen) convert2(e *exprNode, from, to ir.TypeID) {
 	}
 	t := g.tc.MustType(to)
 	if t.Kind() == ir.Pointer && isZeroExpr(e) {
-		g.w("nil")
+		g.null(t)
 		return
 	}
 
@@ -1953,7 +2359,7 @@ func (g *gen) convert2(e *exprNode, from, to ir.TypeID) {
 			switch y := e.Op.(type) {
 			case *ir.Const32:
 				// *(*t)(unsafe.Pointer(&struct{f uintptr}{e}))
-				g.w("*(*%v)(unsafe.Pointer(&struct{f uintptr}{%v}))", g.typ(t), uintptr(y.Value))
+				g.w("*(*%v)(unsafe.Pointer(&struct{f uintptr}{%v}))", g.typ(t), g.uintptrValue(uint64(y.Value)))
 			case *ir.Convert:
 				g.convert2(e.Childs[0], from, to)
 			default:
@@ -1967,12 +2373,28 @@ func (g *gen) convert2(e *exprNode, from, to ir.TypeID) {
 	}
 
 	if et.Kind() == ir.Pointer && isIntegralType(to) {
-		g.w("(%v)(%s.P2U(", g.typ(t), crt)
+		g.w("(%v)(%s.P2U(", g.typ(t), g.crt)
 		g.convert(e, idVoidPtr)
 		g.w("))")
 		return
 	}
 
+	switch et.Kind() {
+	case ir.Complex64, ir.Complex128, ir.Complex256:
+		switch t.Kind() {
+		case ir.Complex64, ir.Complex128, ir.Complex256:
+			g.w("%v(", g.typ(t))
+			g.expression(e, false)
+			g.w(")")
+		default:
+			// The imaginary part is discarded.
+			g.w("(%v)(real(", g.typ(t))
+			g.expression(e, false)
+			g.w("))")
+		}
+		return
+	}
+
 	if t.Kind() == ir.Complex64 {
 		g.w("complex(")
 		g.convert(e, idFloat32)
@@ -1980,7 +2402,7 @@ func (g *gen) convert2(e *exprNode, from, to ir.TypeID) {
 		return
 	}
 
-	if t.Kind() == ir.Complex128 {
+	if k := t.Kind(); k == ir.Complex128 || k == ir.Complex256 {
 		g.w("complex(")
 		g.convert(e, idFloat64)
 		g.w(", 0)")
@@ -1992,7 +2414,7 @@ func (g *gen) convert2(e *exprNode, from, to ir.TypeID) {
 	case t.Kind() == ir.Pointer:
 		switch {
 		case isIntegralType(from):
-			g.w("%s.U2P(uintptr", crt)
+			g.w("%s.U2P(uintptr", g.crt)
 			g.expression(e, false)
 			g.w(")")
 		default:
@@ -2007,82 +2429,99 @@ func (g *gen) convert2(e *exprNode, from, to ir.TypeID) {
 	g.w(")")
 }
 
-func (g *gen) emit(n *node, lastVoid bool, nextLabel int) {
-	var r bool
-	for i, op := range n.Ops {
-		switch x := op.(type) {
-		case *expr:
-			if g.expression2(x.Expr, true, nextLabel) {
-				r = true
-			}
-			g.w("\n")
-		case *ir.Jmp:
-			g.w("goto ")
-			g.label(x.NameID, x.Number)
-			g.w("\n")
-		case *ir.Label:
-			if g.lblUsed[label(x)] == 0 {
-				break
-			}
+// varDecl writes the initialization of a local variable, if any.
+func (g *gen) varDecl(x *ir.VariableDeclaration) {
+	if x.Value == nil {
+		return
+	}
 
-			g.label(x.NameID, x.Number)
-			g.w(":\n")
-		case *ir.Return:
-			if i != len(n.Ops)-1 || !lastVoid {
-				g.w("return\n")
-			}
-		case *ir.VariableDeclaration:
-			if x.Value == nil {
-				break
+	nfo := g.f.varNfo[x.Index]
+	sc := nfo.scope
+	if sc == 0 {
+		sc = -1
+	}
+	nm := g.mangle(x.NameID, false, sc)
+	t := g.tc.MustType(x.TypeID)
+	var it ir.Type
+	if t.Kind() == ir.Array {
+		it = t.(*ir.ArrayType).Item
+	}
+	switch {
+	case it != nil && it.Kind() == ir.Int8:
+		n := t.(*ir.ArrayType).Items
+		switch y := x.Value.(type) {
+		case *ir.CompositeValue:
+			g.w("%s = [%v]int8{", nm, n)
+			for _, v := range y.Values {
+				g.w("%v, ", int8(v.(*ir.Int32Value).Value))
 			}
+			g.w("}")
+		case *ir.StringValue:
+			g.w("%s.Xstrncpy(nil, &%v[0],", g.crt, nm)
+			g.value(x.Position, x.TypeID, x.Value)
+			g.w(", %v)", n)
+		default:
+			g.w("%s = ", nm)
+			g.value(x.Pos(), x.TypeID, x.Value)
+		}
+	default:
+		g.w("%s = ", nm)
+		g.value(x.Pos(), x.TypeID, x.Value)
+	}
+	g.w("\n")
+}
 
-			nfo := g.f.varNfo[x.Index]
-			sc := nfo.scope
-			if sc == 0 {
-				sc = -1
-			}
-			nm := g.mangle(x.NameID, false, sc)
-			t := g.tc.MustType(x.TypeID)
-			var it ir.Type
-			if t.Kind() == ir.Array {
-				it = t.(*ir.ArrayType).Item
+func (g *gen) signature(f *ir.FunctionDefinition, ft *ir.FunctionType) ir.NameID {
+	nm := g.mangle(f.NameID, f.Linkage == ir.ExternalLinkage, -1)
+	g.w("func %v(tls *%v.TLS", nm, g.crt)
+	switch {
+	case f.NameID == idMain && len(ft.Arguments) != 2:
+		g.w(", _ int32, _ **int8) (r0 int32)")
+	default:
+		for i, v := range ft.Arguments {
+			if i < len(f.Arguments) {
+				g.w(", %v ", g.mangle(f.Arguments[i], false, -1))
 			}
-			switch {
-			case it != nil && it.Kind() == ir.Int8:
-				n := t.(*ir.ArrayType).Items
-				switch y := x.Value.(type) {
-				case *ir.CompositeValue:
-					g.w("%s = [%v]int8{", nm, n)
-					for _, v := range y.Values {
-						g.w("%v, ", int8(v.(*ir.Int32Value).Value))
-					}
-					g.w("}")
-				case *ir.StringValue:
-					g.w("%s.Xstrncpy(nil, &%v[0],", crt, nm)
-					g.value(x.Position, x.TypeID, x.Value)
-					g.w(", %v)", n)
-				default:
-					TODO("%s: %T", x.Pos(), y)
+			g.w("%s", g.typ(v))
+		}
+		if ft.Variadic {
+			g.w(", args ...interface{}")
+		}
+		g.w(")")
+		if len(ft.Results) != 0 {
+			g.w("(")
+			for i, v := range ft.Results {
+				if i != 0 {
+					g.w(", ")
 				}
-			default:
-				g.w("%s = ", nm)
-				g.value(x.Pos(), x.TypeID, x.Value)
+				g.w("r%v %s", i, g.typ(v))
 			}
-			g.w("\n")
-		case
-			*ir.AllocResult,
-			*ir.Arguments,
-			*ir.BeginScope,
-			*ir.EndScope:
-
-			// nop
-		default:
-			TODO("%s: %T", x.Pos(), x)
+			g.w(")")
 		}
 	}
-	if r {
-		g.w("}\n")
-	}
+	return nm
+}
+
+// stub replaces the definition of f, which could not be translated, by a
+// function with the same signature panicking with the reason d. Failing to
+// produce the stub is reported as false.
+func (g *gen) stub(f *ir.FunctionDefinition, d *Diagnostic) (ok bool) {
+	out := g.out
+	g.out = &buffer.Bytes{}
+
+	defer func() {
+		b := g.out
+		g.out = out
+		if ok = recover() == nil; ok {
+			g.out.Write(b.Bytes())
+		}
+		b.Close()
+	}()
+
+	g.w("%s", f.Comment)
+	g.signature(f, g.tc.MustType(f.TypeID).(*ir.FunctionType))
+	g.w("{\npanic(%q)\n}\n\n", fmt.Sprintf("%v: %s", d.Position, d.Msg))
+	return true
 }
 
 func (g *gen) functionDefinition(oi int, f *ir.FunctionDefinition) {
@@ -2102,31 +2541,12 @@ func (g *gen) functionDefinition(oi int, f *ir.FunctionDefinition) {
 	defer buf.Close()
 
 	g.w("%s", f.Comment)
-	nm := g.mangle(f.NameID, f.Linkage == ir.ExternalLinkage, -1)
-	g.w("func %v(tls *%v.TLS", nm, crt)
-	switch {
-	case f.NameID == idMain && len(ft.Arguments) != 2:
-		g.w(", _ int32, _ **int8) (r0 int32)")
-	default:
-		for i, v := range ft.Arguments {
-			if i < len(f.Arguments) {
-				g.w(", %v ", g.mangle(f.Arguments[i], false, -1))
-			}
-			g.w("%s", g.typ(v))
-		}
-		if ft.Variadic {
-			g.w(", args ...interface{}")
-		}
-		g.w(")")
-		if len(ft.Results) != 0 {
-			//TODO support multiple results.
-			g.w("(r0 %s)", g.typ(ft.Results[0]))
-		}
-	}
+	nm := g.signature(f, ft)
 	g.w("{\n")
 	if FTrace {
 		g.w("ftrace(%q)\n", nm)
 	}
+	nodes := newGraph(g, g.computedGotos(oi, f.Body))
 	m := map[ir.TypeID][]varNfo{}
 	for i, v := range g.f.varNfo {
 		v.i = i
@@ -2161,21 +2581,7 @@ func (g *gen) functionDefinition(oi int, f *ir.FunctionDefinition) {
 		g.w(" %v\n", g.typ2(t))
 	}
 
-	nodes := newGraph(g, f.Body)
-	g.collectLabels(nodes)
-	for i, v := range nodes {
-		nextLabel := mathutil.MinInt
-		if i < len(nodes)-1 {
-			if x, ok := nodes[i+1].Ops[0].(*ir.Label); ok {
-				nextLabel = int(x.NameID)
-				if nextLabel == 0 {
-					nextLabel = -x.Number
-				}
-			}
-		}
-		g.emit(v, i == len(nodes)-1 && len(ft.Results) == 0, nextLabel)
-	}
-	ok := true
+	term := g.structured(nodes, len(ft.Results) == 0)
 	for _, v := range g.f.varNfo {
 		if v.r == 0 && v.def.NameID != 0 {
 			sc := v.scope
@@ -2184,31 +2590,99 @@ func (g *gen) functionDefinition(oi int, f *ir.FunctionDefinition) {
 			}
 			nm := g.mangle(v.def.NameID, false, sc)
 			g.w("_ = %v\n", nm)
-			ok = false
+			term = false
 		}
 	}
-	if !ok && len(ft.Results) != 0 {
+	if !term && len(ft.Results) != 0 {
 		g.w("panic(0)\n")
 	}
 	g.w("}\n\n")
 }
 
-func (g *gen) unionValue(pos token.Position, ft ir.Type, val []ir.Value) []byte {
-	switch {
-	case len(val) == 0:
-		return nil
-	case len(val) != 1:
-		TODO("%s: %v, %v", pos, ft, val)
+// valueBytes writes the memory image of v, a value of type t, to b. It reports
+// false if the image depends on addresses known only at run time.
+func (g *gen) valueBytes(b []byte, t ir.Type, v ir.Value) bool {
+	switch x := v.(type) {
+	case nil:
+		return true
+	case *ir.CompositeValue:
+		switch t := t.(type) {
+		case *ir.ArrayType:
+			sz := g.model.Sizeof(t.Item)
+			ok := true
+			designated(x, func(i int, v ir.Value) {
+				ok = ok && int64(i) < t.Items && g.valueBytes(b[int64(i)*sz:], t.Item, v)
+			})
+			return ok
+		case *ir.StructOrUnionType:
+			if t.Kind() == ir.Union {
+				k, v := g.unionMember(x)
+				return k < len(t.Fields) && g.valueBytes(b, t.Fields[k], v)
+			}
+
+			l := g.model.Layout(t)
+			ok := true
+			designated(x, func(i int, v ir.Value) {
+				ok = ok && i < len(t.Fields) && g.valueBytes(b[l[i].Offset:], t.Fields[i], v)
+			})
+			return ok
+		}
+	case *ir.Int32Value:
+		return g.numBytes(b, t, int64(x.Value), float64(x.Value))
+	case *ir.Int64Value:
+		return g.numBytes(b, t, x.Value, float64(x.Value))
+	case *ir.Float32Value:
+		return g.numBytes(b, t, int64(x.Value), float64(x.Value))
+	case *ir.Float64Value:
+		return g.numBytes(b, t, int64(x.Value), x.Value)
+	case *ir.Complex64Value:
+		if t.Kind() == ir.Complex64 {
+			*(*complex64)(unsafe.Pointer(&b[0])) = x.Value
+			return true
+		}
+	case *ir.Complex128Value:
+		switch t.Kind() {
+		case ir.Complex128, ir.Complex256:
+			*(*complex128)(unsafe.Pointer(&b[0])) = x.Value
+			return true
+		}
 	}
+	return false
+}
 
-	b := make([]byte, g.model.Sizeof(ft))
-	switch ft.Kind() {
-	case ir.Int32:
-		*(*int32)(unsafe.Pointer(&b[0])) = val[0].(*ir.Int32Value).Value
+// numBytes writes the number n, or f if t is a floating point type, as a
+// value of type t to b. It reports false for pointers other than null.
+func (g *gen) numBytes(b []byte, t ir.Type, n int64, f float64) bool {
+	switch t.Kind() {
+	case ir.Int8, ir.Uint8:
+		b[0] = byte(n)
+	case ir.Int16, ir.Uint16:
+		*(*uint16)(unsafe.Pointer(&b[0])) = uint16(n)
+	case ir.Int32, ir.Uint32:
+		*(*uint32)(unsafe.Pointer(&b[0])) = uint32(n)
+	case ir.Int64, ir.Uint64:
+		*(*uint64)(unsafe.Pointer(&b[0])) = uint64(n)
+	case ir.Float32:
+		*(*float32)(unsafe.Pointer(&b[0])) = float32(f)
+	case ir.Float64, ir.Float128:
+		*(*float64)(unsafe.Pointer(&b[0])) = f
+	case ir.Complex64:
+		*(*complex64)(unsafe.Pointer(&b[0])) = complex(float32(f), 0)
+	case ir.Complex128, ir.Complex256:
+		*(*complex128)(unsafe.Pointer(&b[0])) = complex(f, 0)
+	case ir.Pointer:
+		return n == 0
 	default:
-		TODO("%s: %v, %T(%v)", pos, ft, val[0], val[0])
+		return false
 	}
-	return b
+	return true
+}
+
+// unionMember returns the index and the value of the member initialized by
+// the union initializer x. The last designator wins.
+func (g *gen) unionMember(x *ir.CompositeValue) (k int, v ir.Value) {
+	designated(x, func(i int, w ir.Value) { k, v = i, w })
+	return k, v
 }
 
 func (g *gen) fld(t ir.TypeID, i int) string {
@@ -2222,16 +2696,55 @@ func (g *gen) fld(t ir.TypeID, i int) string {
 	return strconv.Itoa(i)
 }
 
+// complexBuiltin writes the call of the C library function nm as the
+// equivalent Go code if nm is a complex number function handled inline.
+func (g *gen) complexBuiltin(nm ir.NameID, ft *ir.FunctionType, args []*exprNode) bool {
+	if len(args) != 1 {
+		return false
+	}
+
+	s := strings.TrimPrefix(nm.String(), "__builtin_")
+	switch s {
+	case "creal", "crealf", "creall":
+		g.w("real(")
+	case "cimag", "cimagf", "cimagl":
+		g.w("imag(")
+	case "conj", "conjf", "conjl":
+		g.w("func(c %[1]v) %[1]v { return complex(real(c), -imag(c)) }(", g.typ(ft.Arguments[0]))
+	default:
+		return false
+	}
+
+	g.convert(args[0], ft.Arguments[0].ID())
+	g.w(")")
+	return true
+}
+
+// null writes the null pointer of type t.
+func (g *gen) null(t ir.Type) {
+	switch t.ID() {
+	case idVoidPtr:
+		g.w("unsafe.Pointer(nil)")
+	default:
+		g.w("(%v)(nil)", g.typ(t))
+	}
+}
+
 func (g *gen) value(pos token.Position, id ir.TypeID, v ir.Value) {
 	t := g.tc.MustType(id)
 	switch x := v.(type) {
 	case *ir.AddressValue:
 		if x.Label != 0 {
-			TODO("")
+			switch {
+			case id == idVoidPtr:
+				g.w("unsafe.Pointer(%s)", g.labelToken(pos, x, x.Index, x.Label))
+			default:
+				g.w("(%v)(unsafe.Pointer(%s))", g.typ(t), g.labelToken(pos, x, x.Index, x.Label))
+			}
 			break
 		}
 
-		nm := g.mangle(x.NameID, x.Linkage == ir.ExternalLinkage, -1)
+		nm := g.global(pos, x, x.Index)
 		if x.Offset == 0 {
 			switch {
 			case id == idVoidPtr:
@@ -2239,7 +2752,7 @@ func (g *gen) value(pos token.Position, id ir.TypeID, v ir.Value) {
 			case t.Kind() == ir.Pointer && t.(*ir.PointerType).Element.Kind() == ir.Function:
 				s := ""
 				if g.isBuiltin(x.Index) {
-					s = fmt.Sprintf("%s.", crt)
+					s = fmt.Sprintf("%s.", g.crt)
 				}
 				t = t.(*ir.PointerType).Element
 				ft := g.tc.MustType(g.fns[nm].TypeID)
@@ -2255,40 +2768,86 @@ func (g *gen) value(pos token.Position, id ir.TypeID, v ir.Value) {
 			break
 		}
 
-		g.w("(%v)(uintptr(unsafe.Pointer(&%v))+%v)", g.typ2(id), g.mangle(x.NameID, x.Linkage == ir.ExternalLinkage, -1), x.Offset)
+		if g.portable {
+			if p, ok := g.fieldPath(g.tc.MustType(g.obj[x.Index].Base().TypeID), int64(x.Offset)); ok {
+				g.w("(%v)(unsafe.Pointer(&%v%s))", g.typ2(id), nm, p)
+				break
+			}
+		}
+
+		g.w("(%v)(uintptr(unsafe.Pointer(&%v))+%v)", g.typ2(id), nm, x.Offset)
 	case *ir.CompositeValue:
 		switch t := g.tc.MustType(id); t.Kind() {
 		case ir.Array:
 			et := t.(*ir.ArrayType).Item.ID()
 			g.w("%v{", g.typ(t))
 			if !isZeroValue(x) {
-				for _, v := range x.Values {
+				next := 0
+				designated(x, func(i int, v ir.Value) {
+					if i != next {
+						g.w("%v: ", i)
+					}
 					g.value(pos, et, v)
 					g.w(", ")
-				}
+					next = i + 1
+				})
 			}
 			g.w("}")
 		case ir.Struct:
-			f := t.(*ir.StructOrUnionType).Fields
+			st := t.(*ir.StructOrUnionType)
+			f := st.Fields
+			designated(x, func(i int, v ir.Value) {
+				if i == len(f)-1 && g.flexible(st) && !isZeroValue(v) {
+					g.unsupported(pos, x, "initializer of flexible array member of type %v", t)
+				}
+			})
+
+			if g.packed(st) {
+				g.w("func() (r %v) {", g.typ(t))
+				designated(x, func(i int, v ir.Value) {
+					if !isZeroValue(v) {
+						g.w("*r.X%s() = ", g.fld(t.ID(), i))
+						g.value(pos, f[i].ID(), v)
+						g.w("\n")
+					}
+				})
+				g.w("return r }()")
+				break
+			}
+
 			g.w("%v{", g.typ(t))
 			if !isZeroValue(x) {
-				for i, v := range x.Values {
-					if isZeroValue(v) {
-						continue
+				designated(x, func(i int, v ir.Value) {
+					if !isZeroValue(v) {
+						g.w("X%s: ", g.fld(t.ID(), i))
+						g.value(pos, f[i].ID(), v)
+						g.w(", ")
 					}
-
-					g.w("X%s: ", g.fld(t.ID(), i))
-					g.value(pos, f[i].ID(), v)
-					g.w(", ")
-				}
+				})
 			}
 			g.w("}")
 		case ir.Union:
-			ft := t.(*ir.StructOrUnionType).Fields[0]
+			st := t.(*ir.StructOrUnionType)
+			k, v := g.unionMember(x)
+			b := make([]byte, g.model.Sizeof(t))
+			// In portable mode the image must not depend on the target.
+			if !isZeroValue(x) && (g.portable && !isPortable(st.Fields[k]) || !g.valueBytes(b, st.Fields[k], v)) {
+				g.w("func() (r %v) { *r.X%s() = ", g.typ(t), g.fld(t.ID(), k))
+				g.value(pos, st.Fields[k].ID(), v)
+				g.w("\nreturn r }()")
+				break
+			}
+
 			g.w("%v{", g.typ(t))
 			if !isZeroValue(x) {
-				g.w("U: [%v]byte{", g.model.Sizeof(t))
-				for _, v := range g.unionValue(pos, ft, x.Values) {
+				switch {
+				case g.portable && !isPortable(t):
+					b = b[:g.model.Sizeof(st.Fields[k])]
+					g.w("U: [len(%v{}.U)]byte{", g.typ(t))
+				default:
+					g.w("U: [%v]byte{", g.model.Sizeof(t))
+				}
+				for _, v := range b {
 					switch {
 					case v < 10:
 						g.w("%v,", v)
@@ -2313,15 +2872,24 @@ func (g *gen) value(pos token.Position, id ir.TypeID, v ir.Value) {
 					}
 					g.w("}")
 				default:
-					TODO("%s: TODO1250 %v:%v, %v", pos, eet, eet.Kind(), x.Values)
+					g.unsupported(pos, x, "composite value of type %v", t)
 				}
 			default:
-				TODO("%s: TODO1247 %v:%v", pos, et, et.Kind())
+				g.unsupported(pos, x, "composite value of type %v", t)
 			}
 		default:
-			TODO("%s: TODO782 %v:%v", pos, t, t.Kind())
+			g.unsupported(pos, x, "composite value of type %v", t)
 		}
+	case *ir.Complex64Value:
+		g.complexConst(id, complex128(x.Value))
+	case *ir.Complex128Value:
+		g.complexConst(id, x.Value)
 	case *ir.Float32Value:
+		if k := t.Kind(); k == ir.Complex64 || k == ir.Complex128 || k == ir.Complex256 {
+			g.complexConst(id, complex(float64(x.Value), 0))
+			break
+		}
+
 		if id != idFloat32 {
 			g.w("(%v)(", g.typ2(id))
 		}
@@ -2335,7 +2903,12 @@ func (g *gen) value(pos token.Position, id ir.TypeID, v ir.Value) {
 			g.w(")")
 		}
 	case *ir.Float64Value:
-		if id != idFloat64 {
+		if k := t.Kind(); k == ir.Complex64 || k == ir.Complex128 || k == ir.Complex256 {
+			g.complexConst(id, complex(x.Value, 0))
+			break
+		}
+
+		if id != idFloat64 && id != idFloat128 {
 			g.w("(%v)(", g.typ2(id))
 		}
 		switch {
@@ -2344,7 +2917,7 @@ func (g *gen) value(pos token.Position, id ir.TypeID, v ir.Value) {
 		default:
 			g.w("%v", x.Value)
 		}
-		if id != idFloat64 {
+		if id != idFloat64 && id != idFloat128 {
 			g.w(")")
 		}
 	case *ir.Int32Value:
@@ -2352,9 +2925,9 @@ func (g *gen) value(pos token.Position, id ir.TypeID, v ir.Value) {
 		case ir.Pointer:
 			switch x.Value {
 			case 0:
-				g.w("nil")
+				g.null(t)
 			default:
-				g.w("(%v)(%s.U2P(%v))", g.typ(t), crt, uintptr(x.Value))
+				g.w("(%v)(%s.U2P(%v))", g.typ(t), g.crt, g.uintptrValue(uint64(x.Value)))
 			}
 		case ir.Int8:
 			g.w("int8(%v)", int8(x.Value))
@@ -2374,19 +2947,21 @@ func (g *gen) value(pos token.Position, id ir.TypeID, v ir.Value) {
 			g.w("uint64(%v)", uint64(x.Value))
 		case ir.Float32:
 			g.w("float32(%v)", x.Value)
-		case ir.Float64:
+		case ir.Float64, ir.Float128:
 			g.w("float64(%v)", x.Value)
+		case ir.Complex64, ir.Complex128, ir.Complex256:
+			g.complexConst(id, complex(float64(x.Value), 0))
 		default:
-			TODO("%s: %v", pos, t.Kind())
+			g.unsupported(pos, x, "integer value of type %v", t)
 		}
 	case *ir.Int64Value:
 		switch t.Kind() {
 		case ir.Pointer:
 			switch x.Value {
 			case 0:
-				g.w("nil")
+				g.null(t)
 			default:
-				g.w("(%v)(%s.U2P(%v))", g.typ(t), crt, uintptr(x.Value))
+				g.w("(%v)(%s.U2P(%v))", g.typ(t), g.crt, g.uintptrValue(uint64(x.Value)))
 			}
 		case ir.Int8:
 			g.w("int8(%v)", int8(x.Value))
@@ -2406,19 +2981,21 @@ func (g *gen) value(pos token.Position, id ir.TypeID, v ir.Value) {
 			g.w("uint64(%v)", uint64(x.Value))
 		case ir.Float32:
 			g.w("float32(%v)", x.Value)
-		case ir.Float64:
+		case ir.Float64, ir.Float128:
 			g.w("float64(%v)", x.Value)
+		case ir.Complex64, ir.Complex128, ir.Complex256:
+			g.complexConst(id, complex(float64(x.Value), 0))
 		default:
-			TODO("%s: %v", pos, t.Kind())
+			g.unsupported(pos, x, "integer value of type %v", t)
 		}
 	case *ir.StringValue:
 		if x.Offset != 0 {
-			TODO("%s", pos)
+			g.unsupported(pos, x, "string value with offset")
 		}
 
 		g.w("str(%v)", g.string(x.StringID))
 	default:
-		TODO("%s: %T", pos, x)
+		g.unsupported(pos, x, "value")
 	}
 }
 
@@ -2468,11 +3045,11 @@ outer:
 			}
 			g.w("}")
 		case *ir.StringValue:
-			g.w("%s.Xstrncpy(nil, &%v[0],", crt, nm)
+			g.w("%s.Xstrncpy(nil, &%v[0],", g.crt, nm)
 			g.value(d.Position, d.TypeID, d.Value)
 			g.w(", %v)", n)
 		default:
-			TODO("%s: %T", d.Position, y)
+			g.unsupported(d.Position, y, "initializer of type %v", t)
 		}
 	case it != nil && it.Kind() == ir.Uint8:
 		n := t.(*ir.ArrayType).Items
@@ -2484,11 +3061,11 @@ outer:
 			}
 			g.w("}")
 		case *ir.StringValue:
-			g.w("%s.Xstrncpy(nil, (*int8)(unsafe.Pointer(&%v[0])),", crt, nm)
+			g.w("%s.Xstrncpy(nil, (*int8)(unsafe.Pointer(&%v[0])),", g.crt, nm)
 			g.value(d.Position, d.TypeID, d.Value)
 			g.w(", %v)", n)
 		default:
-			TODO("%s: %T", d.Position, y)
+			g.unsupported(d.Position, y, "initializer of type %v", t)
 		}
 	case it != nil && it.ID() == idUint8Ptr:
 		switch x := d.Value.(type) {
@@ -2500,13 +3077,13 @@ outer:
 					g.w("%s = %v{(*byte)(unsafe.Pointer(str(%v)))}", nm, g.typ(t), g.string(y.StringID))
 					break outer
 				default:
-					TODO("%s: %T", d.Position, y)
+					g.unsupported(d.Position, y, "initializer of type %v", t)
 				}
 			default:
-				TODO("%s: t %v it %v", d.Position, t, it)
+				g.unsupported(d.Position, x, "initializer of type %v", t)
 			}
 		default:
-			TODO("%s: %T", d.Position, x)
+			g.unsupported(d.Position, x, "initializer of type %v", t)
 		}
 		fallthrough
 	default:
@@ -2524,18 +3101,75 @@ func (g *gen) helpers(m map[ir.TypeID]struct{}) (r []typeNfo) {
 	return r
 }
 
+// object generates the definition of g.obj[i]. A failure to translate the
+// object is recorded in g.errs and its partial output, if any, is discarded.
+// Objects using long double are reported as a warning.
+func (g *gen) object(i int, v ir.Object) {
+	out := g.out
+	g.out = &buffer.Bytes{}
+	g.lossy = false
+	g.diags = nil
+
+	defer func() {
+		b := g.out
+		g.out = out
+		e := recover()
+		if e == nil && len(g.diags) == 0 {
+			g.out.Write(b.Bytes())
+			b.Close()
+			if g.lossy {
+				g.warn(&Diagnostic{Position: v.Base().Position, Op: fmt.Sprintf("%T", v), Severity: Warning, Msg: "long double lowered to float64, its size differs from sizeof(long double)"})
+			}
+			return
+		}
+
+		b.Close()
+		a := g.diags
+		if e != nil {
+			d, ok := e.(*Diagnostic)
+			if !ok {
+				d = &Diagnostic{Op: fmt.Sprintf("%T", v), Severity: Error, Msg: fmt.Sprint(e)}
+			}
+			a = append(a, d)
+		}
+		for _, d := range a {
+			if !d.Position.IsValid() {
+				d.Position = v.Base().Position
+			}
+		}
+		if f, ok := v.(*ir.FunctionDefinition); ok && g.stubs && g.stub(f, a[0]) {
+			for _, d := range a {
+				d.Severity = Warning
+				g.warn(d)
+			}
+			return
+		}
+
+		g.errs = append(g.errs, a...)
+	}()
+
+	switch x := v.(type) {
+	case *ir.FunctionDefinition:
+		g.functionDefinition(i, x)
+	case *ir.DataDefinition:
+		g.dataDefinition(x)
+	default:
+		panic("internal error")
+	}
+}
+
 func (g *gen) gen() error {
-	g.w("package foo\n")
 	for i, v := range g.obj {
-		switch x := v.(type) {
-		case *ir.FunctionDefinition:
-			g.functionDefinition(i, x)
-		case *ir.DataDefinition:
-			g.dataDefinition(x)
-		default:
-			panic("internal error")
+		g.object(i, v)
+		if n := g.out.Len(); len(g.defs) == 0 || n != g.defs[len(g.defs)-1] {
+			g.defs = append(g.defs, n)
 		}
 	}
+	if len(g.errs) != 0 {
+		return g.errs
+	}
+
+	g.sections[0] = g.out.Len()
 	g.w("func bool2int(b bool) int32 { if b { return 1}; return 0 }\n")
 	g.w("func bug20530(interface{}) {} //TODO remove when https://github.com/golang/go/issues/20530 is fixed.\n")
 	g.w("func init() { nzf32 *= -1; nzf64 *= -1 }\n")
@@ -2546,32 +3180,39 @@ func (g *gen) gen() error {
 		g.w("func copy%d(d, s *%[2]v) *%[2]v { *d = *s; return d }\n", g.reg(v.TypeID), g.typ2(v.TypeID))
 	}
 	for _, v := range g.helpers(g.elems) {
-		t := g.tc.MustType(v.TypeID)
-		sz := g.model.Sizeof(t.(*ir.PointerType).Element)
+		sz := g.sizeof(g.tc.MustType(v.TypeID).(*ir.PointerType).Element)
 		g.w("func elem%d(a %[2]v, index uintptr) %[2]v { return (%[2]v)(unsafe.Pointer(uintptr(unsafe.Pointer(a))+%[3]v*index)) }\n", g.reg(v.TypeID), g.typ2(v.TypeID), sz)
 	}
-	for _, v := range g.helpers(g.postIncs) {
-		switch {
-		case g.tc.MustType(v.TypeID).Kind() == ir.Pointer:
-			g.w("func postInc%d(p *%[2]v, d int) %[2]v { q := (*uintptr)(unsafe.Pointer(p)); v := *q; *q += uintptr(d); return (%[2]v)(unsafe.Pointer(v)) }\n", g.reg(v.TypeID), g.typ2(v.TypeID))
-		default:
-			g.w("func postInc%d(p *%[2]v, d %[2]v) %[2]v { v := *p; *p += d; return v }\n", g.reg(v.TypeID), g.typ2(v.TypeID))
-		}
-	}
-	for _, v := range g.helpers(g.preIncs) {
-		switch {
-		case g.tc.MustType(v.TypeID).Kind() == ir.Pointer:
-			g.w("func preInc%d(p *%[2]v, d int) %[2]v { q := (*uintptr)(unsafe.Pointer(p)); v := *q + uintptr(d); *q = v; return (%[2]v)(unsafe.Pointer(v)) }\n", g.reg(v.TypeID), g.typ2(v.TypeID))
-		default:
-			g.w("func preInc%d(p *%[2]v, d %[2]v) %[2]v { v := *p + d; *p = v; return v }\n", g.reg(v.TypeID), g.typ2(v.TypeID))
+	var tabs []int
+	for k := range g.labelTabs {
+		tabs = append(tabs, k)
+	}
+	sort.Ints(tabs)
+	for _, v := range tabs {
+		g.w("var labels%d [%d]byte // Label addresses of %v.\n", v, g.labelTabs[v], g.obj[v].Base().NameID)
+	}
+	for _, v := range []struct {
+		m    map[ir.TypeID]struct{}
+		nm   string
+		pre  bool
+		bits bool
+	}{
+		{g.postbits, "postbits", false, true},
+		{g.postIncs, "postInc", false, false},
+		{g.prebits, "prebits", true, true},
+		{g.preIncs, "preInc", true, false},
+	} {
+		for _, w := range g.helpers(v.m) {
+			g.incHelper(v.nm, w.TypeID, v.pre, v.bits)
 		}
 	}
 	for _, v := range g.helpers(g.storebits) {
-		g.w("func storebits%d(p *%[2]v, v %[2]v, m uint64, o uint) %[2]v { *p = *p&^%[2]v(m)|(v<<o&%[2]v(m)); return v }\n", g.reg(v.TypeID), g.typ2(v.TypeID))
+		g.w("func storebits%d(p *%[2]v, v %[2]v, m uint64, o, s uint) %[2]v { *p = *p&^%[2]v(m)|(v<<o&%[2]v(m)); return v<<s>>s }\n", g.reg(v.TypeID), g.typ2(v.TypeID))
 	}
 	for _, v := range g.helpers(g.stores) {
 		g.w("func store%d(p *%[2]v, v %[2]v) %[2]v { *p = v; return v }\n", g.reg(v.TypeID), g.typ2(v.TypeID))
 	}
+	g.sections[1] = g.out.Len()
 	defined := map[ir.TypeID]struct{}{}
 	var a []int
 	for k, v := range g.tm {
@@ -2584,6 +3225,8 @@ func (g *gen) gen() error {
 	for _, v := range a {
 		id := ir.TypeID(v)
 		g.w("\ntype %s %v // t%d %v\n", g.tm[id], g.fullType(id), g.reg(id), id)
+		g.accessors(g.tm[id], g.tc.MustType(id))
+		g.layoutAsserts(g.tm[id], g.tc.MustType(id))
 	}
 more:
 	a = a[:0]
@@ -2596,11 +3239,15 @@ more:
 	for _, v := range a {
 		id := ir.TypeID(v)
 		g.w("\ntype t%d %v // %v\n", g.reg(id), g.fullType(id), id)
+		nm := fmt.Sprintf("t%d", g.reg(id))
+		g.accessors(nm, g.tc.MustType(id))
+		g.layoutAsserts(nm, g.tc.MustType(id))
 		defined[id] = struct{}{}
 	}
 	if len(a) != 0 {
 		goto more
 	}
+	g.sections[2] = g.out.Len()
 	if g.strings.Len() != 0 {
 		g.w("func str(n int) *int8 { return (*int8)(unsafe.Pointer(&strTab[n]))}\n")
 		g.w("func wstr(n int) *int32 { return (*int32)(unsafe.Pointer(&strTab[n]))}\n") //TODO Windows UTF-16
@@ -2619,20 +3266,178 @@ more:
 		}
 		g.w("\")\n")
 	}
-	return newOpt(g).opt()
+	return nil
+}
+
+// header returns the build constraints, package clause and import declaration
+// of the code in g.out. Only packages referred to by the code are imported.
+func (g *gen) header(o *options) ([]byte, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), "irgo.out", g.out.Bytes(), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	used := map[string]bool{}
+	for _, v := range f.Unresolved {
+		used[v.Name] = true
+	}
+	var std, other []string
+	for _, v := range []string{"math", "unsafe"} {
+		if used[v] {
+			std = append(std, strconv.Quote(v))
+		}
+	}
+	imports := map[string]string{} // Import path: name.
+	if used[g.crt] {
+		imports[o.crtPath] = g.crt
+	}
+	for k, v := range g.imports {
+		if used[k.String()] {
+			imports[v] = k.String()
+		}
+	}
+	for k := range imports {
+		other = append(other, k)
+	}
+	sort.Strings(other)
+	for i, v := range other {
+		s := strconv.Quote(v)
+		if nm := imports[v]; nm != path.Base(v) {
+			s = nm + " " + s
+		}
+		other[i] = s
+	}
+
+	var buf bytes.Buffer
+	if o.goarch != "" {
+		fmt.Fprintf(&buf, "//go:build %s && %s\n// +build %[1]s,%[2]s\n\n", o.goos, o.goarch)
+	}
+	fmt.Fprintf(&buf, "package %s\n", o.pkg)
+	if len(std)+len(other) == 0 {
+		return buf.Bytes(), nil
+	}
+
+	buf.WriteString("\nimport (\n")
+	for _, v := range std {
+		fmt.Fprintf(&buf, "\t%s\n", v)
+	}
+	if len(std) != 0 && len(other) != 0 {
+		buf.WriteByte('\n')
+	}
+	for _, v := range other {
+		fmt.Fprintf(&buf, "\t%s\n", v)
+	}
+	buf.WriteString(")\n")
+	return buf.Bytes(), nil
+}
+
+// file writes src, a part of the code in g.out, to w as a Go source file.
+func (g *gen) file(w io.Writer, src []byte, o *options) (err error) {
+	out := g.out
+	g.out = &buffer.Bytes{}
+
+	defer func() {
+		g.out.Close()
+		g.out = out
+	}()
+
+	g.w("package foo\n")
+	g.out.Write(src)
+	if err := newOpt(g).opt(); err != nil {
+		return err
+	}
+
+	var hdr []byte
+	if o.pkg != "" {
+		if hdr, err = g.header(o); err != nil {
+			return err
+		}
+	}
+
+	b := g.out.Bytes()
+	i := bytes.IndexByte(b, '\n')
+	b = b[i+1:] // Remove package clause.
+	b = re.ReplaceAll(b, []byte("\n$1"))
+	b = re2.ReplaceAll(b, []byte("\n$1"))
+	b = re3.ReplaceAll(b, nil)
+	b = re4.ReplaceAll(b, []byte("{\n"))
+	if _, err = w.Write(hdr); err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
 }
 
 var (
-	re  = regexp.MustCompile(`\n\n\treturn`)
-	re2 = regexp.MustCompile(`\n\n}`)
-	re3 = regexp.MustCompile(`\n\t;`)
-	re4 = regexp.MustCompile(`\n\n\t\treturn`)
-	re5 = regexp.MustCompile(`\n\n\t}`)
-	re6 = regexp.MustCompile(`{\n\n`)
+	re  = regexp.MustCompile(`\n\n(\t+return)`)
+	re2 = regexp.MustCompile(`\n\n(\t*(}|case |default:))`)
+	re3 = regexp.MustCompile(`\n\t+;`)
+	re4 = regexp.MustCompile(`{\n\n`)
 )
 
+// Severity classifies a Diagnostic.
+type Severity int
+
+// Values of type Severity.
+const (
+	Error Severity = iota
+	Warning
+)
+
+// String implements fmt.Stringer.
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Diagnostic describes an IR construct New could not translate.
+type Diagnostic struct {
+	Position token.Position
+	Op       string // Go type of the IR operation, value or type, eg. "*ir.JmpP".
+	Severity Severity
+	Msg      string
+}
+
+// Error implements error.
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%v: %v: %s (%s)", d.Position, d.Severity, d.Msg, d.Op)
+}
+
+// ErrorList is a list of Diagnostics. It is the error returned by New when
+// some of the objects could not be translated. A failure outside of the
+// expressions of a function ends the translation of its object, so only the
+// first such failure of an object is listed.
+type ErrorList []*Diagnostic
+
+// Error implements error.
+func (l ErrorList) Error() string {
+	var a []string
+	for _, v := range l {
+		a = append(a, v.Error())
+	}
+	return strings.Join(a, "\n")
+}
+
 type options struct {
-	tc ir.TypeCache
+	continueOnError bool
+	crtName         string
+	crtPath         string
+	goarch          string
+	goos            string
+	imports         map[ir.NameID]string
+	maxFileSize     int
+	model           ir.MemoryModel
+	pkg             string
+	portable        bool
+	tc              ir.TypeCache
+	warnings        func(*Diagnostic)
 }
 
 // Option is a configuration/setup function that can be passed to the New
@@ -2647,39 +3452,262 @@ func TypeCache(tc ir.TypeCache) Option {
 	}
 }
 
+// ContinueOnError option requests to replace every function that cannot be
+// translated by a stub with the same signature. Calling the stub panics with
+// the position and the reason of the failure. The failures are reported as
+// warnings.
+func ContinueOnError() Option {
+	return func(o *options) error {
+		o.continueOnError = true
+		return nil
+	}
+}
+
+// Warnings option requests to pass every Diagnostic with Severity Warning to
+// f.
+func Warnings(f func(*Diagnostic)) Option {
+	return func(o *options) error {
+		o.warnings = f
+		return nil
+	}
+}
+
+// Package option requests to produce a complete Go source file with a package
+// clause for package name and an import declaration of all the packages the
+// generated code refers to.
+func Package(name string) Option {
+	return func(o *options) error {
+		if !token.IsIdentifier(name) {
+			return fmt.Errorf("invalid package name: %q", name)
+		}
+
+		o.pkg = name
+		return nil
+	}
+}
+
+// isReserved reports whether nm is an identifier the generated code declares
+// or imports itself, so it cannot name another imported package.
+func isReserved(nm string) bool {
+	switch nm {
+	case
+		"args",
+		"bool2int",
+		"bug20530",
+		"inf",
+		"math",
+		"nzf32",
+		"nzf64",
+		"str",
+		"strTab",
+		"tls",
+		"unsafe",
+		"wstr":
+
+		return true
+	}
+
+	// Numbered helpers and types.
+	for _, v := range []string{"copy", "elem", "labels", "postInc", "postbits", "preInc", "prebits", "store", "storebits", "t"} {
+		if s := strings.TrimPrefix(nm, v); s != nm && s != "" && strings.Trim(s, "0123456789") == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// importName returns the last element of importPath not counting a major
+// version suffix like "v2".
+func importName(importPath string) string {
+	nm := path.Base(importPath)
+	if dir := path.Dir(importPath); dir != "." && len(nm) > 1 && nm[0] == 'v' && nm[1] != '0' && strings.Trim(nm[1:], "0123456789") == "" {
+		return path.Base(dir)
+	}
+
+	return nm
+}
+
+// CRT option sets the import path of the C runtime package and the name by
+// which the generated code refers to it. The defaults are
+// "github.com/cznic/crt" and "crt". An empty name means the last element of
+// importPath, skipping a major version suffix. The import path is used only
+// together with the Package option.
+func CRT(importPath, name string) Option {
+	return func(o *options) error {
+		if name == "" {
+			name = importName(importPath)
+		}
+		switch {
+		case !token.IsIdentifier(name):
+			return fmt.Errorf("invalid C runtime package name: %q", name)
+		case isReserved(name):
+			return fmt.Errorf("C runtime package name conflicts with generated code: %q", name)
+		}
+
+		o.crtName = name
+		o.crtPath = importPath
+		return nil
+	}
+}
+
+// Imports option enables references to objects having a non zero Package.
+// The map m gives the Go import path of every such package. The generated code
+// refers to an imported package by the name of the package in the IR.
+func Imports(m map[ir.NameID]string) Option {
+	return func(o *options) error {
+		if o.imports == nil {
+			o.imports = map[ir.NameID]string{}
+		}
+		for k, v := range m {
+			switch nm := k.String(); {
+			case !token.IsIdentifier(nm):
+				return fmt.Errorf("invalid package name: %q", nm)
+			case isReserved(nm):
+				return fmt.Errorf("package name conflicts with generated code: %q", nm)
+			}
+
+			o.imports[k] = v
+		}
+		return nil
+	}
+}
+
+// Portable option requests code that does not depend on the architecture it
+// was generated on. Sizes that differ between 32 and 64 bit targets are
+// expressed using unsafe.Sizeof and addresses of static objects with an offset
+// are expressed using field selectors and indices where possible. Note that
+// sizes already computed in the IR, for example by the sizeof operator, remain
+// fixed.
+func Portable() Option {
+	return func(o *options) error {
+		o.portable = true
+		return nil
+	}
+}
+
+// Target option makes the generated code use the memory model of goos/goarch
+// instead of the one of the host. Supported architectures are 386, amd64, arm
+// and arm64. Together with the Package option the produced files have the
+// corresponding build constraints.
+func Target(goos, goarch string) Option {
+	return func(o *options) error {
+		model, ok := models[goarch]
+		if !ok {
+			return fmt.Errorf("unsupported target architecture: %q", goarch)
+		}
+
+		if !token.IsIdentifier(goos) {
+			return fmt.Errorf("invalid target operating system: %q", goos)
+		}
+
+		o.goarch = goarch
+		o.goos = goos
+		o.model = model
+		return nil
+	}
+}
+
+// MaxFileSize option sets the approximate size limit of the files with function
+// and data definitions produced by NewFiles. A definition larger than n gets a
+// file of its own. The default is 256 kB.
+func MaxFileSize(n int) Option {
+	return func(o *options) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid file size: %v", n)
+		}
+
+		o.maxFileSize = n
+		return nil
+	}
+}
+
 // New writes Go code generated from obj to out.  No package or import clause
-// is generated. The types argument is consulted for named types.
-func New(out io.Writer, obj []ir.Object, types map[ir.TypeID]string, opts ...Option) (err error) {
+// is generated unless the Package option is used. The types argument is
+// consulted for named types.
+//
+// If some objects cannot be translated, nothing is written to out and the
+// returned error is an ErrorList. It reports every unsupported expression of a
+// function but only the first failure elsewhere in an object, for example in a
+// statement or in an initializer.
+func New(out io.Writer, obj []ir.Object, types map[ir.TypeID]string, opts ...Option) error {
+	return generate("irgo.New", obj, types, opts, func(g *gen, o *options) error {
+		return g.file(out, g.out.Bytes(), o)
+	})
+}
+
+// NewFiles is like New but it spreads the generated code over several Go
+// source files of one package. The Package option is required. For every file
+// NewFiles calls sink with the file name and writes the file to the returned
+// writer.
+//
+// Function and data definitions go to files named code0.go, code1.go, ...,
+// grouped in the order of obj until a file reaches the size set by the
+// MaxFileSize option. Helper functions go to helpers.go, type declarations to
+// types.go and the string table to strings.go.
+func NewFiles(sink func(name string) io.Writer, obj []ir.Object, types map[ir.TypeID]string, opts ...Option) error {
+	return generate("irgo.NewFiles", obj, types, opts, func(g *gen, o *options) error {
+		if o.pkg == "" {
+			return fmt.Errorf("irgo.NewFiles: missing Package option")
+		}
+
+		b := g.out.Bytes()
+		var files []string
+		var parts [][]byte
+		start, prev := 0, 0
+		for _, v := range g.defs {
+			if v-start > o.maxFileSize && prev > start {
+				files = append(files, fmt.Sprintf("code%d.go", len(files)))
+				parts = append(parts, b[start:prev])
+				start = prev
+			}
+			prev = v
+		}
+		if prev > start {
+			files = append(files, fmt.Sprintf("code%d.go", len(files)))
+			parts = append(parts, b[start:prev])
+		}
+		for i, v := range []string{"helpers.go", "types.go", "strings.go"} {
+			end := len(b)
+			if i+1 < len(g.sections) {
+				end = g.sections[i+1]
+			}
+			if end > g.sections[i] {
+				files = append(files, v)
+				parts = append(parts, b[g.sections[i]:end])
+			}
+		}
+		for i, v := range files {
+			if err := g.file(sink(v), parts[i], o); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func generate(name string, obj []ir.Object, types map[ir.TypeID]string, opts []Option, finish func(*gen, *options) error) (err error) {
 	var g *gen
+	o := options{crtName: crtName, crtPath: crtImportPath, maxFileSize: defaultMaxFileSize}
 
 	defer func() {
-		switch x := recover().(type) {
-		case nil:
-			b := g.out.Bytes()
-			i := bytes.IndexByte(b, '\n')
-			b = b[i+1:] // Remove package clause.
-			b = re.ReplaceAll(b, []byte("\n\treturn"))
-			b = re2.ReplaceAll(b, []byte("\n}"))
-			b = re3.ReplaceAll(b, nil)
-			b = re4.ReplaceAll(b, []byte("\n\t\treturn"))
-			b = re5.ReplaceAll(b, []byte("\n\t}"))
-			b = re6.ReplaceAll(b, []byte("{\n"))
-			_, err = out.Write(b)
+		if g != nil {
 			if e := g.out.Close(); e != nil && err == nil {
 				err = e
 			}
+		}
+		switch x := recover().(type) {
+		case nil:
 			return
 		case error:
 			err = x
 		default:
-			err = fmt.Errorf("irgo.New: PANIC: %v", x)
+			err = fmt.Errorf("%s: PANIC: %v", name, x)
 		}
-		if err != nil && Testing {
+		if Testing {
 			panic(err)
 		}
 	}()
 
-	var o options
 	for _, v := range opts {
 		if err := v(&o); err != nil {
 			return err
@@ -2690,5 +3718,9 @@ func New(out io.Writer, obj []ir.Object, types map[ir.TypeID]string, opts ...Opt
 		o.tc = ir.TypeCache{}
 	}
 	g = newGen(obj, types, &o)
-	return g.gen()
+	if err := g.gen(); err != nil {
+		return err
+	}
+
+	return finish(g, &o)
 }
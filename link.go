@@ -0,0 +1,211 @@
+// Copyright 2017 The IRGO Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package irgo
+
+import (
+	"fmt"
+
+	"github.com/cznic/ir"
+)
+
+// Link combines the objects of several translation units, as produced by
+// separately compiling several C files, into a single object set suitable for
+// New or NewFiles. Objects are resolved by external linkage across units. The
+// generated helpers are emitted once for the whole set.
+//
+// Duplicate definitions of an external name and conflicting declarations are
+// reported as an ErrorList. Internal linkage names defined in more than one
+// unit are renamed in all but the first such unit so that the resulting Go
+// package has no name clashes.
+//
+// Linking may mutate passed objects.
+func Link(translationUnits ...[]ir.Object) ([]ir.Object, error) {
+	if err := checkDefs(translationUnits); err != nil {
+		return nil, err
+	}
+
+	renameInternals(translationUnits)
+	hasMain := false
+	for _, v := range translationUnits {
+		for _, v := range v {
+			if x, ok := v.(*ir.FunctionDefinition); ok && x.NameID == idMain && x.Linkage == ir.ExternalLinkage {
+				hasMain = true
+			}
+		}
+	}
+	obj, err := ir.LinkLib(translationUnits...)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasMain {
+		// ir.LinkLib adds a main function when there's none. Turn it
+		// into a declaration so no code is generated for it.
+		for i, v := range obj {
+			if x, ok := v.(*ir.FunctionDefinition); ok && x.NameID == idMain {
+				obj[i] = &ir.FunctionDefinition{ObjectBase: x.ObjectBase, Body: []ir.Operation{&ir.Panic{}}}
+			}
+		}
+	}
+	return obj, nil
+}
+
+func isDeclaration(f *ir.FunctionDefinition) bool {
+	if len(f.Body) != 1 {
+		return false
+	}
+
+	_, ok := f.Body[0].(*ir.Panic)
+	return ok
+}
+
+func checkDefs(units [][]ir.Object) error {
+	var errs ErrorList
+	defs := map[ir.NameID]ir.Object{}
+	for _, v := range units {
+		for _, v := range v {
+			b := v.Base()
+			if b.Linkage != ir.ExternalLinkage || b.Package != 0 {
+				continue
+			}
+
+			prev, ok := defs[b.NameID]
+			if !ok {
+				defs[b.NameID] = v
+				continue
+			}
+
+			pb := prev.Base()
+			msg := ""
+			switch x := v.(type) {
+			case *ir.DataDefinition:
+				switch y := prev.(type) {
+				case *ir.DataDefinition:
+					switch {
+					case x.TypeID != y.TypeID:
+						msg = "conflicting types for %s, previous definition at %v"
+					case x.Value != nil && y.Value != nil:
+						msg = "duplicate definition of %s, previous definition at %v"
+					case y.Value == nil:
+						defs[b.NameID] = v
+					}
+				default:
+					msg = "%s redeclared as a different kind of symbol, previous definition at %v"
+				}
+			case *ir.FunctionDefinition:
+				switch y := prev.(type) {
+				case *ir.FunctionDefinition:
+					switch {
+					case isDeclaration(y):
+						defs[b.NameID] = v
+					case !isDeclaration(x):
+						msg = "duplicate definition of %s, previous definition at %v"
+					}
+				default:
+					msg = "%s redeclared as a different kind of symbol, previous definition at %v"
+				}
+			}
+			if msg != "" {
+				errs = append(errs, &Diagnostic{
+					Position: b.Position,
+					Op:       fmt.Sprintf("%T", v),
+					Severity: Error,
+					Msg:      fmt.Sprintf(msg, b.NameID, pb.Position),
+				})
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func renameInternals(units [][]ir.Object) {
+	used := map[ir.NameID]struct{}{}
+	for _, v := range units {
+		for _, v := range v {
+			used[v.Base().NameID] = struct{}{}
+			if x, ok := v.(*ir.FunctionDefinition); ok {
+				for _, v := range x.Arguments {
+					used[v] = struct{}{}
+				}
+			}
+		}
+	}
+
+	seen := map[ir.NameID]struct{}{}
+	for unit, v := range units {
+		m := map[ir.NameID]ir.NameID{}
+		for _, v := range v {
+			b := v.Base()
+			if b.Linkage != ir.InternalLinkage {
+				continue
+			}
+
+			if _, ok := seen[b.NameID]; !ok {
+				seen[b.NameID] = struct{}{}
+				continue
+			}
+
+			for i := unit; ; i++ {
+				nm := ir.NameID(dict.SID(fmt.Sprintf("%s_%d", b.NameID, i)))
+				if _, ok := used[nm]; !ok {
+					used[nm] = struct{}{}
+					m[b.NameID] = nm
+					break
+				}
+			}
+		}
+		if len(m) != 0 {
+			rename(v, m)
+		}
+	}
+}
+
+// rename changes the internal linkage names of a translation unit according
+// to m.
+func rename(unit []ir.Object, m map[ir.NameID]ir.NameID) {
+	var value func(ir.Value)
+	value = func(v ir.Value) {
+		switch x := v.(type) {
+		case *ir.AddressValue:
+			if nm, ok := m[x.NameID]; ok && x.Linkage == ir.InternalLinkage {
+				x.NameID = nm
+			}
+		case *ir.CompositeValue:
+			for _, v := range x.Values {
+				value(v)
+			}
+		case *ir.DesignatedValue:
+			value(x.Value)
+		}
+	}
+
+	for _, v := range unit {
+		b := v.Base()
+		if nm, ok := m[b.NameID]; ok && b.Linkage == ir.InternalLinkage {
+			b.NameID = nm
+		}
+		switch x := v.(type) {
+		case *ir.DataDefinition:
+			value(x.Value)
+		case *ir.FunctionDefinition:
+			for _, v := range x.Body {
+				switch y := v.(type) {
+				case *ir.Const:
+					value(y.Value)
+				case *ir.Global:
+					if nm, ok := m[y.NameID]; ok && y.Linkage == ir.InternalLinkage {
+						y.NameID = nm
+					}
+				case *ir.VariableDeclaration:
+					value(y.Value)
+				}
+			}
+		}
+	}
+}
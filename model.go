@@ -0,0 +1,86 @@
+// Copyright 2017 The IRGO Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package irgo
+
+import (
+	"github.com/cznic/ir"
+)
+
+var (
+	model32 = ir.MemoryModel{
+		ir.Int8:  ir.MemoryModelItem{Align: 1, Size: 1, StructAlign: 1},
+		ir.Int16: ir.MemoryModelItem{Align: 2, Size: 2, StructAlign: 2},
+		ir.Int32: ir.MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
+		ir.Int64: ir.MemoryModelItem{Align: 4, Size: 8, StructAlign: 4},
+
+		ir.Uint8:  ir.MemoryModelItem{Align: 1, Size: 1, StructAlign: 1},
+		ir.Uint16: ir.MemoryModelItem{Align: 2, Size: 2, StructAlign: 2},
+		ir.Uint32: ir.MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
+		ir.Uint64: ir.MemoryModelItem{Align: 4, Size: 8, StructAlign: 4},
+
+		ir.Float32:  ir.MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
+		ir.Float64:  ir.MemoryModelItem{Align: 8, Size: 8, StructAlign: 4},
+		ir.Float128: ir.MemoryModelItem{Align: 8, Size: 16, StructAlign: 4},
+
+		ir.Complex64:  ir.MemoryModelItem{Align: 8, Size: 8, StructAlign: 4},
+		ir.Complex128: ir.MemoryModelItem{Align: 8, Size: 16, StructAlign: 4},
+		ir.Complex256: ir.MemoryModelItem{Align: 8, Size: 32, StructAlign: 4},
+
+		ir.Pointer:  ir.MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
+		ir.Function: ir.MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
+	}
+
+	model64 = ir.MemoryModel{
+		ir.Int8:  ir.MemoryModelItem{Align: 1, Size: 1, StructAlign: 1},
+		ir.Int16: ir.MemoryModelItem{Align: 2, Size: 2, StructAlign: 2},
+		ir.Int32: ir.MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
+		ir.Int64: ir.MemoryModelItem{Align: 8, Size: 8, StructAlign: 8},
+
+		ir.Uint8:  ir.MemoryModelItem{Align: 1, Size: 1, StructAlign: 1},
+		ir.Uint16: ir.MemoryModelItem{Align: 2, Size: 2, StructAlign: 2},
+		ir.Uint32: ir.MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
+		ir.Uint64: ir.MemoryModelItem{Align: 8, Size: 8, StructAlign: 8},
+
+		ir.Float32:  ir.MemoryModelItem{Align: 4, Size: 4, StructAlign: 4},
+		ir.Float64:  ir.MemoryModelItem{Align: 8, Size: 8, StructAlign: 8},
+		ir.Float128: ir.MemoryModelItem{Align: 8, Size: 16, StructAlign: 8},
+
+		ir.Complex64:  ir.MemoryModelItem{Align: 8, Size: 8, StructAlign: 4},
+		ir.Complex128: ir.MemoryModelItem{Align: 8, Size: 16, StructAlign: 8},
+		ir.Complex256: ir.MemoryModelItem{Align: 8, Size: 32, StructAlign: 8},
+
+		ir.Pointer:  ir.MemoryModelItem{Align: 8, Size: 8, StructAlign: 8},
+		ir.Function: ir.MemoryModelItem{Align: 8, Size: 8, StructAlign: 8},
+	}
+
+	// The 32 and 64 bit memory models with long double lowered, as used by
+	// the generated code. The Portable option checks sizes against these.
+	lowered32 = lowerLongDouble(model32)
+	lowered64 = lowerLongDouble(model64)
+
+	// Memory models of the architectures supported by the Target option.
+	models = map[string]ir.MemoryModel{
+		"386":   model32,
+		"amd64": model64,
+		"arm":   model32,
+		"arm64": model64,
+	}
+)
+
+// lowerLongDouble returns a copy of m where long double and its complex
+// counterpart have the layout of float64 and complex128, the Go types they are
+// lowered to. It is the only place the lowering changes a memory model. Sizes
+// the front end computed using the original model, for example
+// sizeof(long double) being 16, no longer match the generated layout, which
+// is why objects using long double are reported by a warning.
+func lowerLongDouble(m ir.MemoryModel) ir.MemoryModel {
+	r := ir.MemoryModel{}
+	for k, v := range m {
+		r[k] = v
+	}
+	r[ir.Float128] = m[ir.Float64]
+	r[ir.Complex256] = m[ir.Complex128]
+	return r
+}
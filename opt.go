@@ -17,8 +17,9 @@ import (
 )
 
 type opt struct {
-	fset *token.FileSet
-	g    *gen
+	fset    *token.FileSet
+	g       *gen
+	results int // Number of results of the current function.
 }
 
 func newOpt(g *gen) *opt {
@@ -68,12 +69,12 @@ func (o *opt) not(n ast.Expr) ast.Expr {
 			x.Y = o.not(x.Y)
 			return x
 		default:
-			TODO("%s: %v", o.pos(n), x.Op)
+			o.g.unsupported(o.pos(n), x, "negation of %v", x.Op)
 		}
 	case *ast.ParenExpr:
 		return o.not(x.X)
 	default:
-		TODO("%s: %T", o.pos(n), x)
+		o.g.unsupported(o.pos(n), x, "negation")
 	}
 	panic("internal error")
 }
@@ -333,7 +334,7 @@ func (o *opt) expr(n *ast.Expr) {
 			}
 		}
 	default:
-		TODO("%s: %T", o.pos(x), x)
+		o.g.unsupported(o.pos(x), x, "Go node in the optimizer")
 	}
 }
 
@@ -375,6 +376,11 @@ func (o *opt) stmt(n *ast.Stmt) {
 		// nop
 	case *ast.ExprStmt:
 		o.expr(&x.X)
+	case *ast.ForStmt:
+		o.stmt(&x.Init)
+		o.expr(&x.Cond)
+		o.stmt(&x.Post)
+		o.blockStmt(x.Body)
 	case *ast.IfStmt:
 		o.stmt(&x.Init)
 		o.expr(&x.Cond)
@@ -391,7 +397,7 @@ func (o *opt) stmt(n *ast.Stmt) {
 		o.expr(&x.Tag)
 		o.blockStmt(x.Body)
 	default:
-		TODO("%s: %T", o.pos(x), x)
+		o.g.unsupported(o.pos(x), x, "Go node in the optimizer")
 	}
 }
 
@@ -416,7 +422,7 @@ func (o *opt) body(l []ast.Stmt) {
 
 			switch y := x.Lhs[0].(type) {
 			case *ast.Ident:
-				if y.Name != "r0" {
+				if y.Name != "r0" || o.results != 1 {
 					break
 				}
 
@@ -443,7 +449,7 @@ func (o *opt) body(l []ast.Stmt) {
 
 				switch y := x2.Lhs[0].(type) {
 				case *ast.Ident:
-					if y.Name != "r0" {
+					if y.Name != "r0" || o.results != 1 {
 						break
 					}
 
@@ -475,20 +481,21 @@ func (o *opt) spec(n *ast.Spec) {
 			o.expr(&x.Values[i])
 		}
 	default:
-		TODO("%s: %T", o.pos(x), x)
+		o.g.unsupported(o.pos(x), x, "Go node in the optimizer")
 	}
 }
 
 func (o *opt) decl(n *ast.Decl) {
 	switch x := (*n).(type) {
 	case *ast.FuncDecl:
+		o.results = x.Type.Results.NumFields()
 		o.blockStmt(x.Body)
 	case *ast.GenDecl:
 		for i := range x.Specs {
 			o.spec(&x.Specs[i])
 		}
 	default:
-		TODO("%s: %T", o.pos(x), x)
+		o.g.unsupported(o.pos(x), x, "Go node in the optimizer")
 	}
 }
 
@@ -498,7 +505,18 @@ func (o *opt) file(n *ast.File) {
 	}
 }
 
-func (o *opt) opt() error {
+func (o *opt) opt() (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			d, ok := e.(*Diagnostic)
+			if !ok {
+				panic(e)
+			}
+
+			err = d
+		}
+	}()
+
 	o.fset = token.NewFileSet()
 	root, err := parser.ParseFile(o.fset, "irgo.out", o.g.out.Bytes(), parser.ParseComments)
 	if err != nil {